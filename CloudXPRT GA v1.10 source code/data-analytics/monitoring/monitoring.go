@@ -23,6 +23,7 @@ import (
 	"math"
 	"os"
 	//"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -150,6 +151,9 @@ func main(){
 	case "GetContextSwitchesByNode":
 		GetContextSwitchesByNode(durationForMetrics,60,12,resultsFolder)
 		break
+	case "GetGobenchStatsByURL":
+		GetGobenchStatsByURL(durationForMetrics,60,12,resultsFolder)
+		break
 	default:
 		fmt.Println("Unknown function")
 	}
@@ -873,6 +877,104 @@ func GetContextSwitchesByNode (duration int,returnRate int, resStep int, resFold
 
 	resf.Sync()
 }
+//func GetGobenchStatsByURL: load generator side of the run, scraped from the /metrics endpoint
+//gobench serves with -metrics. Writes request rate, failure rate and 95th percentile response time
+//by URL, so they can be lined up with the server side metrics of the same period
+func GetGobenchStatsByURL (duration int,returnRate int, resStep int, resFolder string ){
+	s := strings.Split(resFolder, "/")
+	var fileName string = s[1]
+	if _, create_dir_err := os.Stat(resFolder); os.IsNotExist(create_dir_err) {
+		os.Mkdir(resFolder, os.FileMode(0755))
+	}
+	resf, err := os.Create(resFolder + "/" + "gobench_stats" + fileName + ".csv")
+	if err != nil {
+		log.Printf("LOG err creating file gobench stats file%s\n", err )
+		return
+	}
+	defer resf.Close()
+
+	var rateRange = "[" + strconv.Itoa(returnRate) + "s]"
+	var queries = []string{
+		"sum by (url) (rate(gobench_requests_total" + rateRange + "))",
+		"sum by (url) (rate(gobench_failures_total" + rateRange + "))",
+		"histogram_quantile(0.95, sum by (url, le) (rate(gobench_response_time_seconds_bucket" + rateRange + ")))",
+	}
+
+	//url -> timestamp -> one value per query
+	series := make(map[string]map[int64][]string)
+	//timestamps of all queries, a point may have failures only
+	timestampSet := make(map[int64]struct{})
+	for q, queryStr := range queries {
+		var resultJson = runQueryRange(duration,resStep,queryStr)
+		var ro []resultObject
+
+		err2 := json.Unmarshal(resultJson, &ro)
+		if err2 != nil {
+			log.Printf("GetGobenchStatsByURL %s", err2)
+			return
+		}
+		for k := range ro{
+			var metric map[string]string
+			var values [][]interface{}
+			if err := json.Unmarshal(ro[k].Metric, &metric); err != nil {
+				log.Printf("GetGobenchStatsByURL %s", err)
+				return
+			}
+			if err := json.Unmarshal(ro[k].Values, &values); err != nil {
+				log.Printf("GetGobenchStatsByURL %s", err)
+				return
+			}
+
+			url := metric["url"]
+			if _, ok := series[url]; !ok {
+				series[url] = make(map[int64][]string)
+			}
+			for _, v := range values {
+				if len(v) != 2 {
+					log.Printf("GetGobenchStatsByURL malformed value %v", v)
+					return
+				}
+				fTimeStamp, err := strconv.ParseFloat(fmt.Sprintf("%v", v[0]), 64)
+				if err != nil {
+					log.Printf("GetGobenchStatsByURL %s", err)
+					return
+				}
+				ts := int64(fTimeStamp)
+				timestampSet[ts] = struct{}{}
+				row, ok := series[url][ts]
+				if !ok {
+					row = make([]string, len(queries))
+					series[url][ts] = row
+				}
+				row[q] = fmt.Sprintf("%v", v[1])
+			}
+		}
+	}
+
+	resf.WriteString("timestamp,url,requests/s,failures/s,95th percentile response time(s)\n")
+	var urls []string
+	for url := range series {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	timestamps := make([]int64, 0, len(timestampSet))
+	for ts := range timestampSet {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	for _, url := range urls {
+		for _, ts := range timestamps {
+			row, ok := series[url][ts]
+			if !ok {
+				continue
+			}
+			tm := time.Unix(ts, 0)
+			resf.WriteString(tm.String() + "," + url + "," + strings.Join(row, ",") + "\n")
+		}
+	}
+
+	resf.Sync()
+}
 func GetCPUUsageByNode(duration int) {
 	//rate(http_requests_total[5m])[30m:1m]  :: this is: Return the 5-minute rate of the http_requests_total metric for the past 30 minutes, with a resolution of 1 minute.
 	type resultObject struct {
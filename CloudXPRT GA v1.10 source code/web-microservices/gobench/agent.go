@@ -0,0 +1,566 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gobench/bench"
+)
+
+// Distributed mode: a coordinator started with -agents or -spawn runs no
+// clients itself. It splits the clients (and the arrival rate) over agent
+// processes, starts them together and merges their results into one report.
+//
+// Coordinator and agent exchange one JSON message per line:
+//
+//	coordinator -> agent  {"type":"job","job":{"token":"...","args":[...],"files":{...}}}
+//	agent -> coordinator  {"type":"ready"}
+//	coordinator -> agent  {"type":"start"}
+//	coordinator -> agent  {"type":"stop"}   on timeout or interrupt
+//	agent -> coordinator  {"type":"result","result":{...}}
+//
+// Every run of an agent is a new process, with the connection to the
+// coordinator as file descriptor 3, so no state is left from earlier runs.
+//
+// An agent runs the load of anyone who can reach its address and knows its
+// -agenttoken, so it should listen on an address of a private network only.
+// It takes the flags of agentFlags from a job, input files only as contents
+// written by itself, and runs maxAgentRuns jobs at most at the same time.
+const (
+	msgJob    = "job"
+	msgReady  = "ready"
+	msgStart  = "start"
+	msgStop   = "stop"
+	msgResult = "result"
+	msgError  = "error"
+)
+
+// Set in the environment of an agent process to the token of its jobs
+const agentEnv = "GOBENCH_AGENT"
+
+// Runs of an agent at the same time, further coordinators are turned away
+const maxAgentRuns = 4
+
+// Time a coordinator has to send its job once connected
+const agentJobTimeout = 10 * time.Second
+
+// Flags the coordinator keeps to itself, the others are passed on to agents
+var coordinatorFlags = map[string]bool{"c": true, "rate": true, "seed": true, "agent": true, "agents": true,
+	"spawn": true, "fmt": true, "o": true, "hout": true, "metrics": true, "pct": true, "sla": true,
+	"pool": true, "churn": true, "first": true, "agenttoken": true, "ts": true, "tsint": true,
+	"tsfmt": true}
+
+// Flags an agent takes from a job. Input files only come with the job, and
+// flags naming other paths or addresses of the agent are not taken at all.
+var agentFlags = map[string]bool{"r": true, "c": true, "u": true, "k": true, "conn": true, "pool": true,
+	"churn": true, "endpoints": true, "lb": true, "proxy": true, "noproxy": true, "t": true, "tw": true,
+	"tr": true, "auth": true, "cookie": true, "jar": true, "login": true, "token": true, "relogin": true,
+	"first": true, "e": true, "check": true, "rate": true, "arrival": true, "phases": true, "ramp": true,
+	"warmup": true, "cooldown": true, "think": true, "pace": true, "seed": true, "verify": true, "sni": true,
+	"tlsmin": true, "tlsmax": true, "ciphers": true, "resume": true, "proto": true, "h2conns": true,
+	"tsint": true, "tt": true}
+
+// Flags naming input files, their contents are sent along with the job
+var fileFlags = map[string]bool{"f": true, "d": true, "sc": true, "cacert": true, "cert": true, "key": true,
+	"users": true}
+
+type agentMessage struct {
+	Type   string         `json:"type"`
+	Job    *agentJob      `json:"job,omitempty"`
+	Result *bench.Partial `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type agentJob struct {
+	Token string            `json:"token"`
+	Args  []string          `json:"args"`
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// Control connection between coordinator and agent
+type agentConn struct {
+	name   string
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+}
+
+func newAgentConn(name string, conn net.Conn) *agentConn {
+	return &agentConn{name: name, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (a *agentConn) send(msg *agentMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (a *agentConn) receive() (*agentMessage, error) {
+	line, err := a.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	msg := &agentMessage{}
+	if err := json.Unmarshal(line, msg); err != nil {
+		return nil, err
+	}
+	if msg.Type == msgError {
+		return nil, fmt.Errorf("%s", msg.Error)
+	}
+	return msg, nil
+}
+
+// Receive the next message and fail unless it has the expected type
+func (a *agentConn) expect(msgType string) (*agentMessage, error) {
+	msg, err := a.receive()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != msgType {
+		return nil, fmt.Errorf("expected %s message but got %s", msgType, msg.Type)
+	}
+	return msg, nil
+}
+
+func isCoordinator() bool {
+	return agentAddrs != "" || spawnAgents > 0
+}
+
+// Command running one agent process with file as its control connection,
+// taking the job of token only
+func agentCommand(file *os.File, token string) *exec.Cmd {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), agentEnv+"="+token)
+	cmd.ExtraFiles = []*os.File{file}
+	// stdout may carry the report of the coordinator
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// Wait for coordinators on addr and start an agent process for every
+// connection, up to maxAgentRuns at the same time
+func serveAgents(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %s", addr, err.Error())
+	}
+	fmt.Fprintf(progress, "Agent listening on %s\n", listener.Addr())
+
+	runs := make(chan struct{}, maxAgentRuns)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		select {
+		case runs <- struct{}{}:
+		default:
+			fmt.Fprintf(progress, "Coordinator refused from %s, %d runs going on\n", conn.RemoteAddr(), maxAgentRuns)
+			go func(conn net.Conn) {
+				conn.SetWriteDeadline(time.Now().Add(agentJobTimeout))
+				newAgentConn("coordinator", conn).send(&agentMessage{Type: msgError, Error: "agent busy"})
+				conn.Close()
+			}(conn)
+			continue
+		}
+
+		fmt.Fprintf(progress, "Coordinator connected from %s\n", conn.RemoteAddr())
+		file, err := conn.(*net.TCPConn).File()
+		conn.Close()
+		if err != nil {
+			<-runs
+			log.Println(err)
+			continue
+		}
+		cmd := agentCommand(file, agentToken)
+		err = cmd.Start()
+		file.Close()
+		if err != nil {
+			<-runs
+			log.Println(err)
+			continue
+		}
+		go func() {
+			cmd.Wait()
+			<-runs
+		}()
+	}
+}
+
+// Start a local agent process connected through a socket pair
+func spawnAgent(index int, token string) (*agentConn, error) {
+	// the agent must not inherit the end of the coordinator, or it never
+	// sees the coordinator go
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "coordinator")
+	remote := os.NewFile(uintptr(fds[1]), "agent")
+	defer local.Close()
+	defer remote.Close()
+
+	conn, err := net.FileConn(local)
+	if err != nil {
+		return nil, err
+	}
+	cmd := agentCommand(remote, token)
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go cmd.Wait()
+	return newAgentConn("local agent "+strconv.Itoa(index), conn), nil
+}
+
+// Random token of the local agents of a coordinator without -agenttoken
+func newAgentToken() string {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		log.Fatalf("Error making agent token: %s", err.Error())
+	}
+	return hex.EncodeToString(data)
+}
+
+func connectAgents(token string) []*agentConn {
+	var agents []*agentConn
+	for _, addr := range strings.Split(agentAddrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if len(addr) == 0 {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			log.Fatalf("Error connecting to agent %s: %s", addr, err.Error())
+		}
+		agents = append(agents, newAgentConn(addr, conn))
+	}
+	for i := 0; i < spawnAgents; i++ {
+		agent, err := spawnAgent(i, token)
+		if err != nil {
+			log.Fatalf("Error starting local agent: %s", err.Error())
+		}
+		agents = append(agents, agent)
+	}
+	return agents
+}
+
+// Job of an agent running clients of the configured clients, with the flags
+// given to the coordinator
+func agentJobFor(token string, first int, agentClients int) (*agentJob, error) {
+	job := &agentJob{Token: token, Files: make(map[string][]byte)}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if coordinatorFlags[f.Name] || err != nil {
+			return
+		}
+		if fileFlags[f.Name] {
+			job.Files[f.Name], err = ioutil.ReadFile(f.Value.String())
+			return
+		}
+		if !agentFlags[f.Name] {
+			err = fmt.Errorf("-%s cannot be passed on to agents", f.Name)
+			return
+		}
+		job.Args = append(job.Args, "-"+f.Name+"="+f.Value.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the clients of all agents draw different random values and take
+	// different users
+	job.Args = append(job.Args, "-c="+strconv.Itoa(agentClients), "-seed="+strconv.FormatInt(seed+int64(first), 10),
+		"-first="+strconv.Itoa(firstClient+first))
+	if arrivalRate > 0 {
+		rate := arrivalRate * float64(agentClients) / float64(clients)
+		job.Args = append(job.Args, "-rate="+strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	// every agent has its own pool, and closes its share of the connections
+	if poolSize > 0 {
+		agentPool := poolSize * agentClients / clients
+		if agentPool < 1 {
+			agentPool = 1
+		}
+		job.Args = append(job.Args, "-pool="+strconv.Itoa(agentPool))
+	}
+	if connChurn > 0 {
+		churn := connChurn * float64(agentClients) / float64(clients)
+		job.Args = append(job.Args, "-churn="+strconv.FormatFloat(churn, 'f', -1, 64))
+	}
+	// the coordinator writes the time series of the buckets of all agents
+	if seriesFilePath != "" {
+		job.Args = append(job.Args, "-tsint="+strconv.Itoa(seriesInterval))
+	}
+	return job, nil
+}
+
+// Run the configured clients on the agents and merge their results
+func runCoordinator(runner *bench.Runner, signals <-chan os.Signal) *bench.Report {
+	token := agentToken
+	if token == "" {
+		token = newAgentToken()
+	}
+	agents := connectAgents(token)
+	if len(agents) == 0 {
+		log.Fatalf("No agent to run on")
+	}
+	if len(agents) > clients {
+		log.Fatalf("%d clients cannot be split over %d agents", clients, len(agents))
+	}
+
+	fmt.Fprintf(progress, "Dispatching %d clients to %d agents\n", clients, len(agents))
+	first := 0
+	for i, agent := range agents {
+		agentClients := clients / len(agents)
+		if i < clients%len(agents) {
+			agentClients++
+		}
+		job, err := agentJobFor(token, first, agentClients)
+		first += agentClients
+		if err != nil {
+			log.Fatalf("Error preparing job: %s", err.Error())
+		}
+		if err := agent.send(&agentMessage{Type: msgJob, Job: job}); err != nil {
+			log.Fatalf("Error sending job to %s: %s", agent.name, err.Error())
+		}
+	}
+	for _, agent := range agents {
+		if _, err := agent.expect(msgReady); err != nil {
+			log.Fatalf("Agent %s is not ready: %s", agent.name, err.Error())
+		}
+	}
+
+	startTime := time.Now()
+	for _, agent := range agents {
+		if err := agent.send(&agentMessage{Type: msgStart}); err != nil {
+			log.Fatalf("Error starting %s: %s", agent.name, err.Error())
+		}
+	}
+
+	// on timeout or interrupt the agents are asked to stop, and the results
+	// are printed as they come in. A second interrupt exits right away.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if period > 0 {
+		var cancelPeriod context.CancelFunc
+		ctx, cancelPeriod = context.WithTimeout(ctx, time.Duration(period)*time.Second)
+		defer cancelPeriod()
+	}
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+		}
+		stopAgents(agents)
+		<-signals
+		os.Exit(1)
+	}()
+
+	type agentDone struct {
+		index  int
+		result *bench.Partial
+	}
+	finished := make(chan agentDone, len(agents))
+	for i, agent := range agents {
+		go func(i int, agent *agentConn) {
+			msg, err := agent.expect(msgResult)
+			if err != nil {
+				fmt.Fprintf(progress, "No result from %s: %s\n", agent.name, err.Error())
+				finished <- agentDone{index: i}
+				return
+			}
+			finished <- agentDone{index: i, result: msg.Result}
+		}(i, agent)
+	}
+
+	partials := make([]*bench.Partial, len(agents))
+	for range agents {
+		done := <-finished
+		partials[done.index] = done.result
+	}
+	for _, agent := range agents {
+		agent.conn.Close()
+	}
+	return runner.Merge(startTime, partials)
+}
+
+// Ask all agents to stop, they still send the results they have
+func stopAgents(agents []*agentConn) {
+	for _, agent := range agents {
+		agent.send(&agentMessage{Type: msgStop})
+	}
+}
+
+// Write the files of a job into dir and return the flags naming them. The
+// names come from the coordinator, so only those of fileFlags are taken and
+// the files are named by the agent.
+func writeJobFiles(dir string, files map[string][]byte) ([]string, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !fileFlags[name] {
+			return nil, fmt.Errorf("job file of unknown flag %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for i, name := range names {
+		path := filepath.Join(dir, "input"+strconv.Itoa(i))
+		if err := ioutil.WriteFile(path, files[name], 0644); err != nil {
+			return nil, err
+		}
+		args = append(args, "-"+name+"="+path)
+	}
+	return args, nil
+}
+
+// Check that every flag of a job is one of agentFlags, in the -name=value
+// form of agentJobFor
+func checkJobArgs(args []string) error {
+	for _, arg := range args {
+		tokens := strings.SplitN(arg, "=", 2)
+		if len(tokens) != 2 || !strings.HasPrefix(tokens[0], "-") || !agentFlags[tokens[0][1:]] {
+			return fmt.Errorf("job flag %q is not taken by agents", arg)
+		}
+	}
+	return nil
+}
+
+// Agent process: take the job of the coordinator on file descriptor 3, wait
+// for it to start the run and send it the results
+func runAgent(signals <-chan os.Signal) {
+	file := os.NewFile(3, "coordinator")
+	conn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Error opening coordinator connection: %s", err.Error())
+	}
+	coordinator := newAgentConn("coordinator", conn)
+
+	conn.SetReadDeadline(time.Now().Add(agentJobTimeout))
+	msg, err := coordinator.expect(msgJob)
+	if err != nil {
+		log.Fatalf("Error receiving job: %s", err.Error())
+	}
+	conn.SetReadDeadline(time.Time{})
+	if msg.Job == nil ||
+		subtle.ConstantTimeCompare([]byte(msg.Job.Token), []byte(os.Getenv(agentEnv))) != 1 {
+		coordinator.send(&agentMessage{Type: msgError, Error: "wrong agent token"})
+		os.Exit(1)
+	}
+
+	args := msg.Job.Args
+	if err := checkJobArgs(args); err != nil {
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+	dir, err := ioutil.TempDir("", "gobench")
+	if err != nil {
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+	fileArgs, err := writeJobFiles(dir, msg.Job.Files)
+	if err != nil {
+		os.RemoveAll(dir)
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+	if err := flag.CommandLine.Parse(append(args, fileArgs...)); err != nil {
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+	progress = os.Stderr
+	cfg := NewConfig()
+	// -tsint comes with a job only for the time series of the coordinator
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "tsint" {
+			cfg.SeriesInterval = time.Duration(seriesInterval) * time.Second
+		}
+	})
+	runner, err := bench.New(cfg)
+	os.RemoveAll(dir)
+	if err != nil {
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+
+	if err := coordinator.send(&agentMessage{Type: msgReady}); err != nil {
+		log.Fatalf("Error sending ready: %s", err.Error())
+	}
+	if _, err := coordinator.expect(msgStart); err != nil {
+		log.Fatalf("Error waiting for start: %s", err.Error())
+	}
+
+	// a stop message, a lost coordinator or an interrupt end the run
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		coordinator.receive()
+		cancel()
+	}()
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	result, err := runner.RunPartial(ctx)
+	if err != nil {
+		coordinator.send(&agentMessage{Type: msgError, Error: err.Error()})
+		os.Exit(1)
+	}
+	if err := coordinator.send(&agentMessage{Type: msgResult, Result: result}); err != nil {
+		log.Printf("Error sending results: %s", err.Error())
+	}
+}
@@ -0,0 +1,151 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Spawned agents run the test binary, which then acts as gobench
+func TestMain(m *testing.M) {
+	if os.Getenv(agentEnv) != "" {
+		runAgent(make(chan os.Signal))
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestWriteJobFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args, err := writeJobFiles(dir, map[string][]byte{"sc": []byte("scenario"), "f": []byte("urls")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, " ") != "-f="+filepath.Join(dir, "input0")+" -sc="+filepath.Join(dir, "input1") {
+		t.Fatalf("Wrong job file flags %v", args)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "input1")); string(data) != "scenario" {
+		t.Fatalf("Wrong job file content %q", data)
+	}
+
+	for _, name := range []string{"../../etc/cron.d/x", "u", ""} {
+		if _, err := writeJobFiles(dir, map[string][]byte{name: []byte("x")}); err == nil {
+			t.Fatalf("Job file %q should be rejected", name)
+		}
+	}
+}
+
+func TestCheckJobArgs(t *testing.T) {
+	if err := checkJobArgs([]string{"-c=5", "-u=http://10.0.0.1:8070/mc?a=b", "-k=false", "-tsint=2"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{"-f=/etc/passwd", "-cacert=/root/ca.pem", "-replay=/var/log/access.log",
+		"-unix=/var/run/docker.sock", "-agent=:7070", "-o=/tmp/report", "--c=5", "-c", "c=5", "/etc/passwd"} {
+		if err := checkJobArgs([]string{"-c=5", arg}); err == nil {
+			t.Fatalf("Job flag %q should be rejected", arg)
+		}
+	}
+
+	// every flag is kept by the coordinator, sent as a file, taken by
+	// agents or refused on purpose
+	refused := map[string]bool{"unix": true, "replay": true, "target": true, "speed": true}
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		if !coordinatorFlags[f.Name] && !fileFlags[f.Name] && !agentFlags[f.Name] && !refused[f.Name] {
+			t.Fatalf("Flag -%s is unknown to agents", f.Name)
+		}
+		if fileFlags[f.Name] && agentFlags[f.Name] {
+			t.Fatalf("Agents take a path for -%s", f.Name)
+		}
+	})
+}
+
+func TestAgentToken(t *testing.T) {
+	agent, err := spawnAgent(0, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.conn.Close()
+
+	job := &agentJob{Token: "guess", Args: []string{"-u=http://127.0.0.1:1/", "-r=1", "-c=1"}}
+	if err := agent.send(&agentMessage{Type: msgJob, Job: job}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.expect(msgReady); err == nil || err.Error() != "wrong agent token" {
+		t.Fatalf("Expected the job to be refused, got %v", err)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	var served int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&served, 1)
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "coordinator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	urls := filepath.Join(dir, "urls.txt")
+	if err := ioutil.WriteFile(urls, []byte("WEIGHT:1\n"+server.URL+"/mc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the flags of go test are no run flags, the coordinator only sees the
+	// flags of gobench
+	commandLine := flag.CommandLine
+	defer func() { flag.CommandLine = commandLine }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	commandLine.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+
+	// the URL file goes to the agents with the job
+	for name, value := range map[string]string{"f": urls, "c": "5", "r": "4", "spawn": "2", "e": "Monte"} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := runCoordinator(newRunner(NewConfig()), make(chan os.Signal))
+	if report.Requests != 20 || report.Success != 20 || atomic.LoadInt64(&served) != 20 {
+		t.Fatalf("Wrong merged report: %d requests, %d successful, %d served", report.Requests, report.Success,
+			atomic.LoadInt64(&served))
+	}
+	if len(report.URLs) != 1 || report.URLs[0].Responses != 20 {
+		t.Fatalf("Wrong merged URLs %+v", report.URLs)
+	}
+}
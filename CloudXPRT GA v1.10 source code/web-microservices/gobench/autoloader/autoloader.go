@@ -18,7 +18,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -26,12 +28,14 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	linuxproc "github.com/c9s/goprocinfo/linux"
 	"github.com/olekukonko/tablewriter"
+	"gobench/bench"
 )
 
 var (
@@ -43,6 +47,10 @@ var (
 	sla          int
 	timeInterval int
 	expResult    string
+	gobenchPath  string
+	warm         bool
+	search       string
+	precision    int
 )
 
 type Result struct {
@@ -60,8 +68,13 @@ type Result struct {
 	serviceResp     []string
 	elapsed         []string
 	apdexScore      []string
+	failed          bool // more than 10% of the requests failed
+	good            bool // meets the SLA without failing
 }
 
+// Version of the JSON report of the gobench binary (gobench -fmt json)
+const gobenchReportVersion = 1
+
 const (
 	maxRetry     = 5
 	showLocalCPU = false
@@ -97,6 +110,10 @@ func init() {
 	flag.IntVar(&sla, "s", -1, "Service level agreement (in milliseconds)")
 	flag.IntVar(&timeInterval, "ti", 120, "Time interval between tests (in seconds)")
 	flag.StringVar(&expResult, "e", "", "Expected string pattern from response")
+	flag.StringVar(&gobenchPath, "exec", "", "Run the tests with this gobench binary, for example ./gobench, instead of in-process")
+	flag.BoolVar(&warm, "warm", false, "Keep the connections of the clients open from one test to the next (in-process only)")
+	flag.StringVar(&search, "search", searchLinear, "Search strategy for the best throughput meeting the SLA: [linear|binary|golden]")
+	flag.IntVar(&precision, "p", 0, "Precision of the binary and golden searches in clients (default client increase step)")
 }
 
 func printResults(startTime time.Time) {
@@ -109,6 +126,9 @@ func printResults(startTime time.Time) {
 		elapsed = 1
 	}
 
+	// searches other than the linear one do not test in increasing order
+	sort.SliceStable(results, func(i, j int) bool { return results[i].clients < results[j].clients })
+
 	for _, result := range results {
 		buf.WriteString(fmt.Sprintf("Clients:                        %10d clts\n", result.clients))
 		buf.WriteString(fmt.Sprintf("Requests:                       %10d hits\n", result.requests))
@@ -123,7 +143,18 @@ func printResults(startTime time.Time) {
 		buf.WriteString(fmt.Sprintf("===========================================================\n"))
 	}
 
+	best := bestResult(results)
 	buf.WriteString("\n")
+	if sla != -1 {
+		buf.WriteString(fmt.Sprintf("SLA (95th percentile latency):  %10d ms\n", sla))
+	}
+	if best != nil {
+		buf.WriteString(fmt.Sprintf("Best sustainable clients:       %10d clts\n", best.clients))
+		buf.WriteString(fmt.Sprintf("Best sustainable rate:          %10.2f hits/sec\n", best.rate))
+		buf.WriteString(fmt.Sprintf("Best sustainable latency (95%%): %10s ms\n", best.elapsed[0]))
+	} else {
+		buf.WriteString("No test met the SLA without failing\n")
+	}
 	buf.WriteString(fmt.Sprintf("Total Test Time:                %10d sec\n\n", elapsed))
 	outputToStdout(buf.String())
 
@@ -170,7 +201,6 @@ func printResults(startTime time.Time) {
 	csvWriter.Write(csvHeader)
 	table.SetHeader(header)
 	table.SetAutoFormatHeaders(false)
-	var maxResult = &Result{}
 	for _, result := range results {
 		contents := []string{fmt.Sprintf("%d", result.clients),
 			fmt.Sprintf("%d", result.requests),
@@ -183,10 +213,6 @@ func printResults(startTime time.Time) {
 			fmt.Sprintf("%d", result.cpu),
 			fmt.Sprintf("%d", timeInterval)}
 
-		if maxReq == result.success {
-			maxResult = result
-		}
-
 		csvCont := make([]string, len(contents))
 		copy(csvCont, contents)
 
@@ -215,10 +241,10 @@ func printResults(startTime time.Time) {
 		table.Append(contents)
 	}
 
-	// Add the test summary part
-	if maxResult.rate > 0 {
-		table.SetCaption(true, fmt.Sprintf("Best throughput found at %.2f requests per second with 95th percentile latency of %s ms",
-			maxResult.rate, maxResult.elapsed[0]))
+	// Add the test summary part, its lines start with Best for cnbrun and postprocess to skip them
+	if best != nil {
+		table.SetCaption(true, fmt.Sprintf("Best sustainable throughput found at %d clients, %.2f requests per second with 95th percentile latency of %s ms",
+			best.clients, best.rate, best.elapsed[0]))
 	}
 
 	csvWriter.Flush()
@@ -254,107 +280,129 @@ func inputCheck() {
 		flag.Usage()
 		os.Exit(1)
 	}
+
+	if clients <= 0 || (search == searchLinear && clientStep <= 0) {
+		outputToStdout("Start client number and client increase step must be positive")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if search != searchLinear && search != searchBinary && search != searchGolden {
+		outputToStdout("Search strategy must be one of: [linear|binary|golden]")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if precision == 0 {
+		precision = clientStep
+	}
+	if precision <= 0 {
+		outputToStdout("Search precision must be positive")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if warm && gobenchPath != "" {
+		outputToStdout("Connections can only be kept open between in-process tests")
+		flag.Usage()
+		os.Exit(1)
+	}
 }
 
-func parseOutput(out string, clients int, aveCPU int) ([]string, bool) {
-	result := &Result{}
-	result.clients = clients
-	result.cpu = aveCPU
-	if DEBUG {
-		outputToStdout(out)
-	}
-	outArray := strings.Split(out, "\n")
-	for _, line := range outArray {
-		if strings.Contains(line, "Requests:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[1])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.requests = temp
-		}
-		if strings.Contains(line, "Successful requests:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.success = temp
-			if temp > maxReq {
-				maxReq = temp
-				retry = 0
-			} else {
-				retry++
-			}
+// Run a test of the given number of clients in-process, with the progress
+// every 10 seconds, or with the gobench binary
+func runTest(ctx context.Context, clients int, cache *bench.ConnCache) (*bench.Report, error) {
+	if gobenchPath != "" {
+		args := []string{"-c", strconv.Itoa(clients), "-t", strconv.Itoa(timeInterval), "-e", expResult, "-fmt", "json"}
+		if urlsFilePath == "" {
+			args = append([]string{"-u", urlPath}, args...)
+		} else {
+			args = append([]string{"-f", urlsFilePath}, args...)
 		}
-		if strings.Contains(line, "Network failed:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.networkFailed = temp
+		if DEBUG {
+			outputToStdout(gobenchPath + " " + strings.Join(args, " "))
 		}
-		if strings.Contains(line, "Bad requests failed") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[4])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.badFailed = temp
+		out, err := exec.CommandContext(ctx, gobenchPath, args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("Error running %s: %s", gobenchPath, err.Error())
 		}
-		if strings.Contains(line, "Pattern mismatch") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.mismatched = temp
+		if DEBUG {
+			outputToStdout(string(out))
 		}
-		if strings.Contains(line, "Successful requests rate:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.ParseFloat(tokens[3], 32)
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.rate = temp
+
+		var report bench.Report
+		if err := json.Unmarshal(out, &report); err != nil {
+			return nil, fmt.Errorf("Invalid gobench report: %s", err.Error())
 		}
-		if strings.Contains(line, "Read throughput:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			result.readThroughput = temp
+		if report.Version != gobenchReportVersion {
+			return nil, fmt.Errorf("Unsupported gobench report version %d", report.Version)
 		}
-		if strings.Contains(line, "Write throughput:") {
-			tokens := strings.Fields(line)
-			temp, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				log.Fatal(err.Error())
+		return &report, nil
+	}
+
+	cfg := bench.Config{
+		URLFile:          urlsFilePath,
+		Clients:          clients,
+		Period:           time.Duration(timeInterval) * time.Second,
+		Expect:           expResult,
+		ConnCache:        cache,
+		ProgressInterval: 10 * time.Second,
+		OnProgress: func(p bench.Progress) {
+			outputToStdout(fmt.Sprintf("Time Passed: %d seconds, %d requests, %d failed, %d in flight...",
+				int(p.Elapsed.Seconds()+0.5), p.Requests, p.Failed, p.InFlight))
+		}}
+	if urlsFilePath == "" {
+		cfg.URL = urlPath
+	}
+	if DEBUG {
+		cfg.Log = os.Stdout
+	}
+	return bench.Run(ctx, cfg)
+}
+
+func addResult(report *bench.Report, clients int, aveCPU int) (*Result, error) {
+	result := &Result{}
+	result.clients = clients
+	result.cpu = aveCPU
+
+	result.requests = int(report.Requests)
+	result.success = int(report.Success)
+	if result.success > maxReq {
+		maxReq = result.success
+		retry = 0
+	} else {
+		retry++
+	}
+	result.networkFailed = int(report.NetworkFailed)
+	result.badFailed = int(report.BadFailed)
+	result.mismatched = int(report.Mismatched)
+	result.rate = report.SuccessRate
+	result.readThroughput = int(report.ReadThroughput)
+	result.writeThroughput = int(report.WriteThroughput)
+
+	for _, urlReport := range report.URLs {
+		result.serviceName = append(result.serviceName, getServiceName(urlReport.URL))
+		result.serviceResp = append(result.serviceResp, strconv.FormatInt(urlReport.Responses, 10))
+		var p95 int64 = -1
+		for _, pct := range urlReport.Percentiles {
+			if pct.Percentile == 95 {
+				// gobench reports microseconds, autoloader works in milliseconds
+				p95 = pct.Value / 1000
 			}
-			result.writeThroughput = temp
-		}
-		if strings.Contains(line, "For URL:") {
-			result.serviceName = append(result.serviceName, getServiceName(line))
 		}
-		if strings.Contains(line, "Total") {
-			tokens := strings.Fields(line)
-			result.serviceResp = append(result.serviceResp, tokens[1])
+		if p95 < 0 {
+			return nil, fmt.Errorf("No 95th percentile found for URL %s", urlReport.URL)
 		}
-		if strings.Contains(line, "95%") {
-			tokens := strings.Fields(line)
-			result.elapsed = append(result.elapsed, tokens[1])
-		}
-		if strings.Contains(line, "Apdex") {
-			tokens := strings.Fields(line)
-			result.apdexScore = append(result.apdexScore, tokens[3])
+		result.elapsed = append(result.elapsed, strconv.FormatInt(p95, 10))
+		if urlReport.Apdex != nil {
+			result.apdexScore = append(result.apdexScore, fmt.Sprintf("%.5f", urlReport.Apdex.Score))
 		}
 	}
-	results = append(results, result)
-
 	// If network failed is more than 10% of total requests, stop the tests
-	return result.elapsed, float32(result.networkFailed+result.badFailed+result.mismatched)/float32(result.requests) > 0.1
+	result.failed = float32(result.networkFailed+result.badFailed+result.mismatched)/float32(result.requests) > 0.1
+	// without URL reports there is no latency to judge, nor to report as best
+	result.good = !result.failed && len(result.elapsed) > 0 && (sla == -1 || !checkSLA(result.elapsed))
+	results = append(results, result)
+	return result, nil
 }
 
 func cpuProfile() {
@@ -541,7 +589,6 @@ func getAverageCPU(input string) int {
 
 func main() {
 	var curIndex = 0
-	var err error
 	nodeCPU = make(map[string]int)
 	makeOutputDirectory()
 	getNodesList()
@@ -574,7 +621,8 @@ func main() {
 				if false { // aveCPU > 0 && curIndex > (timeInterval/10 - 1) {
 					outputToStdout(fmt.Sprintf("CPU Usage: %d", aveCPU))
 					curIndex = 0
-				} else {
+				} else if gobenchPath != "" {
+					// in-process tests report their own progress
 					outputToStdout("Time Passed: 10 seconds...")
 				}
 			}
@@ -610,33 +658,15 @@ func main() {
 		command = fmt.Sprintf("autoloader -f %s -c %d -ci %d -ti %d\n", urlsFilePath, clients, clientStep, timeInterval)
 	}
 
-	currentClient := clients
-	var out []byte
-	for true {
-		if urlsFilePath == "" {
-			gobenchCmd := fmt.Sprintf("./gobench -u %s -c %d -t %d -e %s\n", urlPath,
-				currentClient, timeInterval, expResult)
-			if DEBUG {
-				outputToStdout(gobenchCmd)
-			}
-			out, err = exec.Command("./gobench", "-u", urlPath, "-c",
-				fmt.Sprintf("%d", currentClient), "-t",
-				fmt.Sprintf("%d", timeInterval), "-e",
-				expResult).Output()
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-		} else {
-			gobenchCmd := fmt.Sprintf("./gobench -f %s -c %d -t %d\n", urlsFilePath, currentClient, timeInterval)
-			if DEBUG {
-				outputToStdout(gobenchCmd)
-			}
-			out, err = exec.Command("./gobench", "-f", urlsFilePath, "-c",
-				fmt.Sprintf("%d", currentClient), "-t",
-				fmt.Sprintf("%d", timeInterval), "-e", expResult).Output()
-			if err != nil {
-				log.Fatal(err.Error())
-			}
+	var cache *bench.ConnCache
+	if warm {
+		cache = &bench.ConnCache{}
+	}
+	measure := func(currentClient int) (*Result, error) {
+		outputToStdout(fmt.Sprintf("Test with %d clients......", currentClient))
+		report, err := runTest(context.Background(), currentClient, cache)
+		if err != nil {
+			return nil, fmt.Errorf("Test with %d clients failed: %s", currentClient, err.Error())
 		}
 
 		// Get the average of local CPU usage, not used any more!
@@ -651,24 +681,46 @@ func main() {
 		}
 		//////////
 
-		elapsed, networkFailed := parseOutput(string(out), currentClient, aveCPU)
-		// If response time longer than SLA, stop. Do not support multi case
-		if sla != -1 && checkSLA(elapsed) {
-			break
-		}
+		return addResult(report, currentClient, aveCPU)
+	}
 
-		// If a lot of network failed happens, something really wrong, system could
-		// run out of resources, so do not continue the tests
-		// If lower than maxReq for more than maxRetry times, get out
-		if networkFailed || retry >= maxRetry {
-			break // get out of the loop
-		}
-		currentClient += clientStep
-		// if user set last client number, stop the tests there
-		if clientEnd != -1 && currentClient > clientEnd {
-			break
+	var err error
+	switch search {
+	case searchBinary:
+		err = newSearcher(measure, clients, clientEnd, precision).binary()
+	case searchGolden:
+		err = newSearcher(measure, clients, clientEnd, precision).golden()
+	default:
+		currentClient := clients
+		for true {
+			var result *Result
+			if result, err = measure(currentClient); err != nil {
+				break
+			}
+			// If response time longer than SLA, or none at all, stop. Do not support multi case
+			if len(result.elapsed) == 0 || (sla != -1 && checkSLA(result.elapsed)) {
+				break
+			}
+
+			// If a lot of network failed happens, something really wrong, system could
+			// run out of resources, so do not continue the tests
+			// If lower than maxReq for more than maxRetry times, get out
+			if result.failed || retry >= maxRetry {
+				break // get out of the loop
+			}
+			currentClient += clientStep
+			// if user set last client number, stop the tests there
+			if clientEnd != -1 && currentClient > clientEnd {
+				break
+			}
 		}
 	}
+	if err != nil {
+		outputToStdout(err.Error())
+		ticker.Stop()
+		printResults(startTime)
+		os.Exit(1)
+	}
 
 	ticker.Stop()
 	printResults(startTime)
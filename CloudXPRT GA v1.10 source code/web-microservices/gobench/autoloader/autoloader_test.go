@@ -0,0 +1,36 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package main
+
+import (
+	"testing"
+
+	"gobench/bench"
+)
+
+func TestAddResultWithoutLatency(t *testing.T) {
+	defer func() { results, maxReq, retry = nil, 0, 0 }()
+
+	// a step without requests has no URL report and no failure ratio
+	result, err := addResult(&bench.Report{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.good || bestResult([]*Result{result}) != nil {
+		t.Fatalf("A result without latency should not be the best %+v", result)
+	}
+}
@@ -3,6 +3,9 @@ module gobench/autoloader
 go 1.14
 
 require (
-	github.com/c9s/goprocinfo v0.0.0-20200311234719-5750cbd54a3b // indirect
+	github.com/c9s/goprocinfo v0.0.0-20200311234719-5750cbd54a3b
 	github.com/olekukonko/tablewriter v0.0.4
+	gobench v0.0.0-00010101000000-000000000000
 )
+
+replace gobench => ../
@@ -0,0 +1,161 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package main
+
+import (
+	"math"
+)
+
+// Strategies of the search for the number of clients with the best
+// throughput meeting the SLA. The linear search adds the client step until
+// a test misses the SLA, fails or does not beat the best throughput for
+// maxRetry tests. The binary search doubles the clients until a test misses
+// the SLA, fails or does not beat the throughput of the last one, then
+// halves the interval between the last good and the first bad number of
+// clients down to the precision. The golden search ramps up the same way,
+// then narrows the interval around the best throughput by golden sections,
+// a test missing the SLA or failing counting as no throughput.
+const (
+	searchLinear = "linear"
+	searchBinary = "binary"
+	searchGolden = "golden"
+)
+
+// 1/phi, the golden ratio section of an interval
+const invPhi = 0.6180339887498949
+
+// Run a test with a number of clients
+type measureFunc func(clients int) (*Result, error)
+
+type searcher struct {
+	measure   measureFunc
+	start     int
+	end       int // -1 without a last number of clients
+	precision int
+	measured  map[int]*Result
+}
+
+func newSearcher(measure measureFunc, start int, end int, precision int) *searcher {
+	return &searcher{measure: measure, start: start, end: end, precision: precision,
+		measured: make(map[int]*Result)}
+}
+
+// Result of a number of clients, tested once only
+func (s *searcher) at(clients int) (*Result, error) {
+	if result, ok := s.measured[clients]; ok {
+		return result, nil
+	}
+	result, err := s.measure(clients)
+	if err != nil {
+		return nil, err
+	}
+	s.measured[clients] = result
+	return result, nil
+}
+
+// Whether result beats the throughput of the number of clients last, none
+// for 0
+func (s *searcher) improves(result *Result, last int) bool {
+	return result.good && (last == 0 || result.rate > s.measured[last].rate)
+}
+
+// Double the clients from the start until a test is no improvement, returns
+// the good number of clients before the last good one, the last good one
+// and the first bad one, 0 for none. At the last number of clients, the
+// first bad one is the last good one.
+func (s *searcher) ramp() (int, int, int, error) {
+	prev, good := 0, 0
+	for clients := s.start; ; clients *= 2 {
+		if s.end != -1 && clients > s.end {
+			clients = s.end
+		}
+		result, err := s.at(clients)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if !s.improves(result, good) {
+			return prev, good, clients, nil
+		}
+		prev, good = good, clients
+		if clients == s.end {
+			return prev, good, good, nil
+		}
+	}
+}
+
+func (s *searcher) binary() error {
+	_, low, high, err := s.ramp()
+	for err == nil && high-low > s.precision {
+		var result *Result
+		middle := (low + high) / 2
+		if result, err = s.at(middle); err == nil {
+			if s.improves(result, low) {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+	}
+	return err
+}
+
+// Throughput of a number of clients meeting the SLA, 0 otherwise
+func (s *searcher) throughput(clients int) (float64, error) {
+	if clients < 1 {
+		return 0, nil
+	}
+	result, err := s.at(clients)
+	if err != nil || !result.good {
+		return 0, err
+	}
+	return result.rate, nil
+}
+
+func (s *searcher) golden() error {
+	low, good, high, err := s.ramp()
+	if err != nil || high == good {
+		return err
+	}
+	for high-low > s.precision {
+		section := int(math.Round(invPhi * float64(high-low)))
+		lower, err := s.throughput(high - section)
+		if err != nil {
+			return err
+		}
+		upper, err := s.throughput(low + section)
+		if err != nil {
+			return err
+		}
+		if lower < upper {
+			low = high - section
+		} else {
+			high = low + section
+		}
+	}
+	return nil
+}
+
+// The result with the best throughput meeting the SLA, nil without any
+func bestResult(results []*Result) *Result {
+	var best *Result
+	for _, result := range results {
+		if result.good && (best == nil || result.rate > best.rate) {
+			best = result
+		}
+	}
+	return best
+}
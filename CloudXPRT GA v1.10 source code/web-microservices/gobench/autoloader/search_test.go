@@ -0,0 +1,85 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package main
+
+import (
+	"testing"
+)
+
+// Throughput growing up to peak clients and falling after, the SLA missed
+// above limit clients
+func cluster(peak int, limit int, tested *[]*Result) measureFunc {
+	return func(clients int) (*Result, error) {
+		rate := float64(100 * clients)
+		if clients > peak {
+			rate = float64(100*peak - 50*(clients-peak))
+		}
+		result := &Result{clients: clients, rate: rate, good: clients <= limit}
+		*tested = append(*tested, result)
+		return result, nil
+	}
+}
+
+func TestSearch(t *testing.T) {
+	expected := []struct {
+		strategy  string
+		start     int
+		end       int
+		peak      int
+		limit     int
+		precision int
+		best      int
+		maxTests  int
+	}{
+		{searchBinary, 5, -1, 40, 60, 1, 40, 10},
+		{searchGolden, 5, -1, 40, 60, 1, 40, 12},
+		{searchBinary, 5, -1, 100, 60, 5, 60, 8},
+		{searchGolden, 5, -1, 100, 60, 5, 60, 14},
+		// at the last number of clients
+		{searchBinary, 5, 30, 40, 60, 1, 30, 4},
+		{searchGolden, 5, 30, 40, 60, 1, 30, 4},
+		// down from a start missing the SLA
+		{searchBinary, 10, -1, 40, 3, 1, 3, 5},
+		{searchGolden, 10, -1, 40, 3, 1, 3, 6},
+	}
+	for _, e := range expected {
+		var tested []*Result
+		s := newSearcher(cluster(e.peak, e.limit, &tested), e.start, e.end, e.precision)
+		var err error
+		if e.strategy == searchBinary {
+			err = s.binary()
+		} else {
+			err = s.golden()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		best := bestResult(tested)
+		if best == nil || best.clients < e.best-e.precision || best.clients > e.best || len(tested) > e.maxTests {
+			t.Fatalf("%s search of %+v found %+v in %d tests", e.strategy, e, best, len(tested))
+		}
+		for _, result := range tested {
+			if result.clients < 1 || (e.end != -1 && result.clients > e.end) {
+				t.Fatalf("%s search of %+v tested %d clients", e.strategy, e, result.clients)
+			}
+		}
+	}
+
+	if bestResult([]*Result{{clients: 10, rate: 100}}) != nil {
+		t.Fatalf("A result missing the SLA should not be the best")
+	}
+}
@@ -0,0 +1,174 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client-side load balancing. With endpoints, every request goes to one of
+// the endpoint addresses picked by the policy instead of the address of its
+// URL host, while its Host header stays that of the URL, so the pods behind
+// a service get their share of the connections without a proxy choosing for
+// them. An endpoint is host:port or dns:name:port, which stands for all A
+// and AAAA records of name resolved at the start, for example the headless
+// service of the pods. Clients keep their connections per endpoint.
+//
+// Policies are round-robin (rr), random and least outstanding requests of
+// the run (least), ties going round-robin. The TLS server name of http1 is
+// the URL host, h2 connections to an endpoint take the configured server
+// name or the endpoint address.
+const (
+	balanceRoundRobin = "rr"
+	balanceRandom     = "random"
+	balanceLeast      = "least"
+)
+
+type balancer struct {
+	// first for its alignment
+	next      uint64
+	policy    string
+	endpoints []*endpoint
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+type endpoint struct {
+	outstanding int64
+	addr        string
+}
+
+// Addresses of the comma separated endpoints of spec, without duplicates
+func resolveEndpoints(spec string, timeout time.Duration) ([]string, error) {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(entry, "dns:")
+		host, port, err := net.SplitHostPort(name)
+		if err != nil || len(host) == 0 || len(port) == 0 {
+			return nil, fmt.Errorf("endpoint %q is not host:port or dns:name:port", entry)
+		}
+
+		resolved := []string{name}
+		if name != entry {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			resolved = resolved[:0]
+			for _, ip := range ips {
+				resolved = append(resolved, net.JoinHostPort(ip.String(), port))
+			}
+			// the order of DNS answers may change between agents
+			sort.Strings(resolved)
+		}
+		for _, addr := range resolved {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no endpoint in %q", spec)
+	}
+	return addrs, nil
+}
+
+func newBalancer(policy string, addrs []string, seed int64) *balancer {
+	b := &balancer{policy: policy, rng: rand.New(rand.NewSource(seed))}
+	for _, addr := range addrs {
+		b.endpoints = append(b.endpoints, &endpoint{addr: addr})
+	}
+	return b
+}
+
+// The endpoint of the next request
+func (b *balancer) pick() *endpoint {
+	count := uint64(len(b.endpoints))
+	switch b.policy {
+	case balanceRandom:
+		b.mu.Lock()
+		i := b.rng.Intn(len(b.endpoints))
+		b.mu.Unlock()
+		return b.endpoints[i]
+	case balanceLeast:
+		first := atomic.AddUint64(&b.next, 1) - 1
+		best := b.endpoints[first%count]
+		for i := uint64(1); i < count; i++ {
+			e := b.endpoints[(first+i)%count]
+			if atomic.LoadInt64(&e.outstanding) < atomic.LoadInt64(&best.outstanding) {
+				best = e
+			}
+		}
+		return best
+	default:
+		return b.endpoints[(atomic.AddUint64(&b.next, 1)-1)%count]
+	}
+}
+
+// Requests sent to one endpoint and their response times
+type endpointResult struct {
+	requests int64
+	failed   int64
+	hist     *Histogram
+}
+
+func mergeEndpoints(merged map[string]*endpointResult, endpoints map[string]*endpointResult) {
+	for addr, stats := range endpoints {
+		total, ok := merged[addr]
+		if !ok {
+			total = &endpointResult{hist: NewHistogram()}
+			merged[addr] = total
+		}
+		total.requests += stats.requests
+		total.failed += stats.failed
+		total.hist.Merge(stats.hist)
+	}
+}
+
+// Requests of every endpoint in the order of their addresses, rates over
+// elapsed seconds
+func endpointReports(endpoints map[string]*endpointResult, elapsed int64) []Endpoint {
+	reports := make([]Endpoint, 0, len(endpoints))
+	for addr, stats := range endpoints {
+		report := Endpoint{Address: addr, Requests: stats.requests, Failed: stats.failed,
+			Mean: stats.hist.Mean(), P50: stats.hist.ValueAtPercentile(50), P95: stats.hist.ValueAtPercentile(95),
+			P99: stats.hist.ValueAtPercentile(99), Max: stats.hist.Max(), Histogram: stats.hist}
+		if elapsed > 0 {
+			report.Rate = float64(stats.requests) / float64(elapsed)
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Address < reports[j].Address })
+	return reports
+}
@@ -0,0 +1,93 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveEndpoints(t *testing.T) {
+	addrs, err := resolveEndpoints("10.0.0.1:8070, 10.0.0.2:8070,10.0.0.1:8070,dns:127.0.0.1:8071", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(addrs, ",") != "10.0.0.1:8070,10.0.0.2:8070,127.0.0.1:8071" {
+		t.Fatalf("Wrong endpoints %v", addrs)
+	}
+
+	for _, spec := range []string{"10.0.0.1", "dns:web-service", ":8070", " , "} {
+		if _, err := resolveEndpoints(spec, time.Second); err == nil {
+			t.Fatalf("%q should be invalid", spec)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	var addrs []string
+	counts := make([]int64, 3)
+	for i := range counts {
+		count := &counts[i]
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the requests keep the host of their URL
+			if r.Host != "web-service.invalid:8070" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			atomic.AddInt64(count, 1)
+			w.Write([]byte("Monte Carlo"))
+		}))
+		defer server.Close()
+		addrs = append(addrs, strings.TrimPrefix(server.URL, "http://"))
+	}
+
+	for _, policy := range []string{balanceRoundRobin, balanceRandom, balanceLeast} {
+		for i := range counts {
+			atomic.StoreInt64(&counts[i], 0)
+		}
+		report, err := Run(context.Background(), Config{URL: "http://web-service.invalid:8070/mc", Clients: 4,
+			Requests: 6, Endpoints: strings.Join(addrs, ","), Balance: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Success != 24 || len(report.Endpoints) != 3 {
+			t.Fatalf("Wrong result of %s: %d successful, endpoints %+v", policy, report.Success, report.Endpoints)
+		}
+		for _, e := range report.Endpoints {
+			for i, addr := range addrs {
+				if e.Address == addr && e.Requests != atomic.LoadInt64(&counts[i]) {
+					t.Fatalf("%s counted %d requests of %s, the server %d", policy, e.Requests, addr, counts[i])
+				}
+			}
+			if policy == balanceRoundRobin && e.Requests != 8 {
+				t.Fatalf("Round-robin sent %d requests to %s", e.Requests, e.Address)
+			}
+		}
+	}
+
+	for _, cfg := range []Config{{Balance: "hash"}, {Balance: balanceLeast}, {Endpoints: "web-service"}} {
+		cfg.URL, cfg.Requests = "http://web-service.invalid:8070/mc", 1
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+}
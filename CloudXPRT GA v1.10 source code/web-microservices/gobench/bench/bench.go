@@ -0,0 +1,766 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+// Package bench is the load generator of gobench. A run is described by a
+// Config and started with Run, or with New and the methods of Runner to
+// serve live metrics or take part in a distributed run:
+//
+//	report, err := bench.Run(ctx, bench.Config{URL: "http://IP:8070/mc", Clients: 10,
+//		Period: time.Minute})
+//
+// A run ends when its clients are done, its period is over or ctx is done,
+// whichever comes first. All state belongs to the run, so runs can be done
+// one after the other or at the same time in one process.
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config of a run. Zero values take the defaults of the gobench command,
+// except for Seed.
+type Config struct {
+	// What to send: a URL, a URL file (URL per line, weighted by WEIGHT:n
+	// lines and with [POST], [EXPECT], [THOLD], [THINK], [SLA] and [CHECK]
+	// options), a scenario file or a replay file
+	URL          string
+	URLFile      string
+	ScenarioFile string
+	ReplayFile   string
+	// scheme://host:port replacing the host of replayed requests
+	ReplayTarget string
+	// Replay speed, 2 replays twice as fast
+	ReplaySpeed float64
+	// Body of the requests of the URLs without [POST]
+	PostDataFile string
+
+	// Requests per client, or Period of the run. Without either, the run
+	// only ends with ctx or the replay file.
+	Requests int64
+	Period   time.Duration
+	Clients  int
+
+	DisableKeepAlive bool
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	// Connection mode of HTTP/1.1 (client|pool|request, see conns.go),
+	// DisableKeepAlive is the request mode. PoolSize is the number of
+	// connections per host of pool mode, ConnChurn the connections closed
+	// per second.
+	ConnMode  string
+	PoolSize  int
+	ConnChurn float64
+	// Comma separated endpoints (host:port or dns:name:port) the requests
+	// of all URLs go to and the policy picking them (rr|random|least, see
+	// balance.go)
+	Endpoints string
+	Balance   string
+	// Proxy URL (http, https or socks5) with the hosts it does not serve,
+	// the proxies of the environment when empty or none when "direct", or a
+	// Unix socket all connections go to (see proxy.go)
+	Proxy      string
+	NoProxy    string
+	UnixSocket string
+	// Clients with their connections kept open for the next run sharing
+	// the cache, http1 client mode only
+	ConnCache *ConnCache
+	// Authorization header, cookie as name=value and expected pattern of
+	// all responses
+	Auth   string
+	Cookie string
+	Expect string
+	// Validation rules of all responses, like status=2xx;json:results#>=1
+	// (see validate.go)
+	Check string
+
+	// Open-loop mode: target request rate of all clients, with fixed or
+	// poisson arrivals
+	ArrivalRate float64
+	Arrival     string
+
+	Percentiles []float64
+	// Time the phases of requests (dial, TLS handshake, TTFB, body)
+	Phases bool
+	// Apdex thresholds of all URLs as lower:upper in milliseconds
+	TimeThreshold string
+	// SLA rules of all URLs, like p95<=500,errors<=1% (see sla.go)
+	SLA string
+
+	Ramp     time.Duration
+	Warmup   time.Duration
+	Cooldown time.Duration
+
+	// Think time after each request (ms, uniform:min-max or exp:mean) and
+	// pacing of the requests or sessions of a client
+	Think string
+	Pace  time.Duration
+	// Client i draws its random values from Seed+i, the order of the URL
+	// file and the Poisson arrivals come from Seed
+	Seed int64
+	// Number of the first client, when the run is one part of a larger run
+	FirstClient int
+
+	// User pool file, login request as URL[POST]body with the value of its
+	// response to keep (variable=json:path, regex:expression or
+	// header:name) and how often to log in again (see identity.go).
+	// CookieJar keeps the cookies set by the responses to each client.
+	Users        string
+	Login        string
+	LoginExtract string
+	LoginEvery   time.Duration
+	CookieJar    bool
+
+	// TLS options, see the flags of the gobench command
+	Verify       bool
+	CACert       string
+	Cert         string
+	Key          string
+	ServerName   string
+	MinVersion   string
+	MaxVersion   string
+	Ciphers      string
+	TLSResume    bool
+	Protocol     string
+	H2Transports int
+
+	// Interval of the time series of the report, none when 0
+	SeriesInterval time.Duration
+
+	// Messages such as the first occurrence of an error go to Log, written
+	// to by all clients at once, and OnProgress is called every
+	// ProgressInterval (1 second by default) while the run goes on. Debug
+	// also logs the URLs read and every failed response.
+	Log              io.Writer
+	Debug            bool
+	OnProgress       func(Progress)
+	ProgressInterval time.Duration
+}
+
+// Invalid combination of options, as opposed to unreadable files
+type ConfigError struct {
+	Msg string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Msg
+}
+
+// Counters of a run in progress
+type Progress struct {
+	Elapsed  time.Duration
+	Requests int64
+	Success  int64
+	Failed   int64
+	InFlight int64
+}
+
+// Parsed configuration of a run, with the values of its files
+type Configuration struct {
+	urls         []string
+	method       string
+	postData     []byte
+	requests     int64
+	period       time.Duration
+	keepAlive    bool
+	authHeader   string
+	cookieHeader string
+	expect       string
+	clients      int
+	arrivalRate  float64
+	arrivalMode  string
+	percentiles  []float64
+	scenario     []*Step
+	phases       bool
+	ramp         time.Duration
+	warmup       time.Duration
+	cooldown     time.Duration
+	replay       []*replayEntry
+	replayFile   string
+	speed        float64
+	think        *thinkTime
+	thinkSpec    string
+	pace         time.Duration
+	seed         int64
+	// time series interval, 0 without time series
+	seriesInterval time.Duration
+
+	// Apdex thresholds of all URLs and of URLs or steps with [THOLD], and
+	// think times of URLs with [THINK]
+	timeThreshold string
+	thresholds    map[string]string
+	thinkTimes    map[string]*thinkTime
+	// SLA rules of all URLs and of URLs or steps with [SLA]
+	sla      []*slaRule
+	slaRules map[string][]*slaRule
+	// validation rules of all URLs and of URLs with [CHECK]
+	check  []*check
+	checks map[string][]*check
+	// connection mode, pool size and churn rate
+	connMode  string
+	poolSize  int
+	connChurn float64
+	// addresses of client-side load balancing and its policy
+	endpoints []string
+	balance   string
+	// proxy of the connections, nil without, and Unix socket
+	proxy      proxyConfig
+	proxySpec  string
+	unixSocket string
+	connCache  *ConnCache
+	// identities of the clients and their login
+	firstClient  int
+	users        []*identity
+	login        string
+	loginExtract *Extract
+	loginEvery   time.Duration
+	cookieJar    bool
+	// values of the CSV columns and counters used in templates
+	csvColumns  map[string][]string
+	seqCounters map[string]*int64
+
+	protocol     string
+	h2Transports int
+	tlsConfig    *tls.Config
+	tlsResume    bool
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	log              io.Writer
+	debug            bool
+	onProgress       func(Progress)
+	progressInterval time.Duration
+}
+
+// Requests per client when only a period or ctx ends the run
+const unlimited = math.MaxInt64
+
+var defaultPercentiles = []float64{50, 60, 70, 80, 90, 95, 99, 99.9, 100}
+
+// Write a message to the log of a run with Debug
+func (configuration *Configuration) debugf(format string, args ...interface{}) {
+	if configuration.debug {
+		fmt.Fprintf(configuration.log, format, args...)
+	}
+}
+
+func configError(format string, args ...interface{}) error {
+	return &ConfigError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// Check cfg and read the files it names
+func newConfiguration(cfg Config) (*Configuration, error) {
+	if cfg.URLFile == "" && cfg.URL == "" && cfg.ScenarioFile == "" && cfg.ReplayFile == "" {
+		return nil, configError("A URL, URL file, scenario or replay file must be provided")
+	}
+	if cfg.Requests < 0 || cfg.Period < 0 {
+		return nil, configError("Requests and period must not be negative")
+	}
+	if cfg.Requests > 0 && cfg.Period > 0 {
+		return nil, configError("Only one should be provided: [requests|period]")
+	}
+	if cfg.Clients == 0 {
+		cfg.Clients = 100
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = 5 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 5 * time.Second
+	}
+	if cfg.Arrival == "" {
+		cfg.Arrival = "fixed"
+	}
+	if cfg.Percentiles == nil {
+		cfg.Percentiles = defaultPercentiles
+	}
+	if cfg.TimeThreshold == "" {
+		cfg.TimeThreshold = "-1"
+	}
+	if cfg.ReplaySpeed == 0 {
+		cfg.ReplaySpeed = 1
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = protoHTTP1
+	}
+	if cfg.H2Transports == 0 {
+		cfg.H2Transports = 1
+	}
+	if cfg.Log == nil {
+		cfg.Log = ioutil.Discard
+	}
+	if cfg.ProgressInterval <= 0 {
+		cfg.ProgressInterval = time.Second
+	}
+	if cfg.Balance == "" {
+		cfg.Balance = balanceRoundRobin
+	}
+	if cfg.ConnMode == "" {
+		cfg.ConnMode = connClient
+		if cfg.DisableKeepAlive {
+			cfg.ConnMode = connRequest
+		}
+	}
+
+	if cfg.Clients < 0 {
+		return nil, configError("Number of clients must be positive")
+	}
+	if cfg.SeriesInterval < 0 {
+		return nil, configError("Time series interval must be positive")
+	}
+	if (cfg.Protocol != protoHTTP1 && cfg.Protocol != protoH2 && cfg.Protocol != protoH2C) || cfg.H2Transports < 1 {
+		return nil, configError("Protocol must be one of: [http1|h2|h2c] and h2conns must be positive")
+	}
+	if (cfg.ConnMode != connClient && cfg.ConnMode != connPool && cfg.ConnMode != connRequest) ||
+		(cfg.DisableKeepAlive && cfg.ConnMode != connRequest) {
+		return nil, configError("Connection mode must be one of: [client|pool|request], request without keep-alive")
+	}
+	if (cfg.ConnMode == connPool) != (cfg.PoolSize > 0) || cfg.PoolSize < 0 {
+		return nil, configError("Pool mode needs a positive pool size, other modes none")
+	}
+	if cfg.ConnChurn < 0 || (cfg.ConnChurn > 0 && cfg.ConnMode == connRequest) {
+		return nil, configError("Churn rate must be positive and needs keep-alive connections")
+	}
+	if cfg.Protocol != protoHTTP1 && (cfg.ConnMode != connClient || cfg.ConnChurn > 0) {
+		return nil, configError("Connection modes and churn only apply to http1")
+	}
+	if cfg.ConnCache != nil && (cfg.Protocol != protoHTTP1 || cfg.ConnMode != connClient) {
+		return nil, configError("A connection cache needs http1 and a connection per client")
+	}
+	if (cfg.Balance != balanceRoundRobin && cfg.Balance != balanceRandom && cfg.Balance != balanceLeast) ||
+		(cfg.Balance != balanceRoundRobin && cfg.Endpoints == "") {
+		return nil, configError("Balancing policy must be one of: [rr|random|least] and needs endpoints")
+	}
+	if cfg.UnixSocket != "" && (cfg.Endpoints != "" || (cfg.Proxy != "" && cfg.Proxy != proxyDirect)) {
+		return nil, configError("A Unix socket takes neither endpoints nor a proxy")
+	}
+	if cfg.NoProxy != "" && (cfg.Proxy == "" || cfg.Proxy == proxyDirect) {
+		return nil, configError("Hosts without proxy need a proxy")
+	}
+	if cfg.ArrivalRate < 0 || (cfg.Arrival != "fixed" && cfg.Arrival != "poisson") {
+		return nil, configError("Rate must be positive and arrival must be one of: [fixed|poisson]")
+	}
+	if cfg.ReplayFile != "" && cfg.ReplaySpeed < 0 {
+		return nil, configError("Replay speed must be positive")
+	}
+	if cfg.Ramp < 0 || cfg.Warmup < 0 || cfg.Cooldown < 0 {
+		return nil, configError("Ramp, warm-up and cool-down periods must not be negative")
+	}
+	if cfg.Cooldown > 0 && (cfg.Period == 0 || cfg.Warmup+cfg.Cooldown >= cfg.Period) {
+		return nil, configError("Cool-down needs a period longer than warm-up and cool-down together")
+	}
+	for _, pct := range cfg.Percentiles {
+		if pct < 0 || pct > 100 {
+			return nil, configError("Invalid percentile %g", pct)
+		}
+	}
+
+	configuration := &Configuration{
+		urls:             make([]string, 0),
+		method:           "GET",
+		postData:         nil,
+		keepAlive:        cfg.ConnMode != connRequest,
+		connMode:         cfg.ConnMode,
+		poolSize:         cfg.PoolSize,
+		connChurn:        cfg.ConnChurn,
+		balance:          cfg.Balance,
+		proxySpec:        cfg.Proxy,
+		unixSocket:       cfg.UnixSocket,
+		connCache:        cfg.ConnCache,
+		firstClient:      cfg.FirstClient,
+		login:            cfg.Login,
+		loginEvery:       cfg.LoginEvery,
+		cookieJar:        cfg.CookieJar,
+		requests:         unlimited,
+		period:           cfg.Period,
+		authHeader:       cfg.Auth,
+		cookieHeader:     cfg.Cookie,
+		expect:           cfg.Expect,
+		clients:          cfg.Clients,
+		arrivalRate:      cfg.ArrivalRate,
+		arrivalMode:      cfg.Arrival,
+		percentiles:      cfg.Percentiles,
+		phases:           cfg.Phases,
+		ramp:             cfg.Ramp,
+		warmup:           cfg.Warmup,
+		cooldown:         cfg.Cooldown,
+		thinkSpec:        cfg.Think,
+		pace:             cfg.Pace,
+		seed:             cfg.Seed,
+		seriesInterval:   cfg.SeriesInterval,
+		timeThreshold:    cfg.TimeThreshold,
+		thresholds:       make(map[string]string),
+		thinkTimes:       make(map[string]*thinkTime),
+		checks:           make(map[string][]*check),
+		slaRules:         make(map[string][]*slaRule),
+		csvColumns:       make(map[string][]string),
+		seqCounters:      make(map[string]*int64),
+		protocol:         cfg.Protocol,
+		h2Transports:     cfg.H2Transports,
+		tlsResume:        cfg.TLSResume,
+		readTimeout:      cfg.ReadTimeout,
+		writeTimeout:     cfg.WriteTimeout,
+		log:              cfg.Log,
+		debug:            cfg.Debug,
+		onProgress:       cfg.OnProgress,
+		progressInterval: cfg.ProgressInterval}
+	if cfg.Requests > 0 {
+		configuration.requests = cfg.Requests
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS options: %s", err.Error())
+	}
+	configuration.tlsConfig = tlsConfig
+
+	if cfg.UnixSocket == "" {
+		proxy, err := newProxyConfig(cfg.Proxy, cfg.NoProxy)
+		if err != nil {
+			return nil, configError("%s", err.Error())
+		}
+		configuration.proxy = proxy
+	}
+
+	if cfg.Endpoints != "" {
+		endpoints, err := resolveEndpoints(cfg.Endpoints, cfg.WriteTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid endpoints: %s", err.Error())
+		}
+		configuration.endpoints = endpoints
+	}
+
+	if cfg.Think != "" || cfg.Pace != 0 {
+		if cfg.ArrivalRate > 0 || cfg.ReplayFile != "" || cfg.Pace < 0 {
+			return nil, configError("Think time and pacing are for closed-loop clients and pacing must be positive")
+		}
+		if cfg.Think != "" {
+			think, err := parseThink(cfg.Think)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid think time: %s", err.Error())
+			}
+			configuration.think = think
+		}
+	}
+
+	if cfg.FirstClient < 0 || cfg.LoginEvery < 0 || (cfg.Login == "" && (cfg.LoginExtract != "" || cfg.LoginEvery > 0)) {
+		return nil, configError("First client and login period must not be negative and need a login request")
+	}
+	if cfg.LoginExtract != "" {
+		extract, err := parseExtract(cfg.LoginExtract)
+		if err != nil {
+			return nil, configError("%s", err.Error())
+		}
+		configuration.loginExtract = extract
+	}
+	if cfg.Users != "" {
+		users, err := readUsers(cfg.Users)
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading user pool file: %s Error: %s", cfg.Users, err.Error())
+		}
+		configuration.users = users
+	}
+
+	if cfg.Check != "" {
+		checks, err := parseChecks(cfg.Check)
+		if err != nil {
+			return nil, configError("%s", err.Error())
+		}
+		configuration.check = checks
+	}
+
+	if cfg.SLA != "" {
+		rules, err := parseSLA(cfg.SLA)
+		if err != nil {
+			return nil, configError("%s", err.Error())
+		}
+		configuration.sla = rules
+	}
+
+	if cfg.URLFile != "" {
+		fileLines, err := configuration.readLines(cfg.URLFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading URL file: %s Error: %s", cfg.URLFile, err.Error())
+		}
+
+		configuration.urls = fileLines
+		configuration.debugf("%v\n", configuration.urls)
+	}
+
+	if len(cfg.URL) > 10 {
+		configuration.urls = append(configuration.urls, cfg.URL)
+	}
+
+	if cfg.ScenarioFile != "" {
+		steps, err := configuration.readScenario(cfg.ScenarioFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading scenario file: %s Error: %s", cfg.ScenarioFile, err.Error())
+		}
+
+		configuration.scenario = steps
+	}
+
+	if cfg.ReplayFile != "" {
+		entries, err := readReplay(configuration.log, cfg.ReplayFile, cfg.ReplayTarget)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading replay file: %s Error: %s", cfg.ReplayFile, err.Error())
+		}
+
+		configuration.replay = entries
+		configuration.replayFile = cfg.ReplayFile
+		configuration.speed = cfg.ReplaySpeed
+	}
+
+	if cfg.PostDataFile != "" {
+		configuration.method = "POST"
+
+		data, err := ioutil.ReadFile(cfg.PostDataFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading post data file: %s Error: %s", cfg.PostDataFile, err.Error())
+		}
+
+		configuration.postData = data
+	}
+
+	templates := append([]string{string(configuration.postData), cfg.Auth, cfg.Cookie, cfg.Expect, cfg.Login},
+		configuration.urls...)
+	for _, template := range templates {
+		if err := configuration.checkTemplate(template); err != nil {
+			return nil, fmt.Errorf("Invalid template: %s", err.Error())
+		}
+	}
+
+	thresholds := []string{configuration.timeThreshold}
+	for _, threshold := range configuration.thresholds {
+		thresholds = append(thresholds, threshold)
+	}
+	for _, threshold := range thresholds {
+		if strings.Contains(threshold, ":") {
+			if _, _, err := handleTimeThreshold(threshold); err != nil {
+				return nil, configError("%s", err.Error())
+			}
+		}
+	}
+
+	if err := configuration.checkSLA(); err != nil {
+		return nil, configError("%s", err.Error())
+	}
+
+	return configuration, nil
+}
+
+// Runner does one run of a configuration
+type Runner struct {
+	// bytes read and written in the measured window and requests in flight,
+	// first for their alignment
+	readBytes    int64
+	writeBytes   int64
+	inFlight     int64
+	h2MaxStreams int64
+	// connections opened, closed and failed to open in the measured window
+	connsOpened int64
+	connsClosed int64
+	connErrors  int64
+
+	configuration *Configuration
+	results       map[int]*Result
+	window        measureWindow
+	series        seriesState
+	h2            *h2State
+	used          int32
+	// shared connections of pool mode and close requests of the churn rate
+	pool  chan *httpClient
+	churn chan struct{}
+	// endpoints of client-side load balancing, nil without
+	balancer *balancer
+
+	// start of the run, read by WriteMetrics at any time
+	mu        sync.Mutex
+	startTime time.Time
+}
+
+// Check cfg and read its files for a run
+func New(cfg Config) (*Runner, error) {
+	configuration, err := newConfiguration(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &Runner{configuration: configuration, results: make(map[int]*Result)}
+	r.series.buckets = make(map[seriesKey]*seriesBucket)
+	for i := 0; i < configuration.clients; i++ {
+		r.results[i] = &Result{}
+	}
+	if configuration.protocol != protoHTTP1 {
+		r.h2 = newH2State(r)
+	}
+	if configuration.connChurn > 0 {
+		r.churn = make(chan struct{}, 1)
+	}
+	if len(configuration.endpoints) > 0 {
+		r.balancer = newBalancer(configuration.balance, configuration.endpoints, configuration.seed)
+	}
+	return r, nil
+}
+
+// Do a run of cfg and report its results
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	r, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return r.Run(ctx)
+}
+
+// Do the run and report its results. The report is taken when the run
+// ends, requests still in flight at that time are left out.
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	var report *Report
+	err := r.execute(ctx, func() { report = r.buildReport() })
+	return report, err
+}
+
+// Do the run and return the results for a coordinator to merge
+func (r *Runner) RunPartial(ctx context.Context) (*Partial, error) {
+	var partial *Partial
+	err := r.execute(ctx, func() { partial = r.partial() })
+	return partial, err
+}
+
+func (r *Runner) start(startTime time.Time) {
+	r.mu.Lock()
+	r.startTime = startTime
+	r.mu.Unlock()
+	r.setWindow(startTime)
+	r.startSeries(startTime)
+}
+
+func (r *Runner) started() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.startTime
+}
+
+// Run the clients until they are done, the period is over or ctx is done.
+// snapshot takes the results at that moment, then the clients are stopped
+// and waited for.
+func (r *Runner) execute(ctx context.Context, snapshot func()) error {
+	if !atomic.CompareAndSwapInt32(&r.used, 0, 1) {
+		return fmt.Errorf("a runner can only run once")
+	}
+	configuration := r.configuration
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if configuration.period > 0 {
+		var cancelPeriod context.CancelFunc
+		ctx, cancelPeriod = context.WithTimeout(ctx, configuration.period)
+		defer cancelPeriod()
+	}
+
+	r.start(time.Now())
+	fmt.Fprintf(configuration.log, "Dispatching %d clients\n", configuration.clients)
+
+	if configuration.connMode == connPool {
+		r.newPool(ctx)
+	}
+	if r.churn != nil {
+		go r.churnConns(ctx)
+	}
+
+	var slots chan time.Time
+	var replaySlots chan replaySlot
+	if configuration.replay != nil {
+		replaySlots = make(chan replaySlot, configuration.clients)
+		go replaySchedule(ctx, configuration, replaySlots)
+	} else if configuration.arrivalRate > 0 {
+		slots = make(chan time.Time, configuration.clients)
+		go schedule(ctx, configuration, slots)
+	}
+
+	var done sync.WaitGroup
+	done.Add(configuration.clients)
+	for i := 0; i < configuration.clients; i++ {
+		vc := &virtualClient{id: i, ctx: ctx, run: r, result: r.results[i], errSet: make(map[string]struct{}),
+			rng:      rand.New(rand.NewSource(configuration.seed + int64(i))),
+			identity: configuration.identityOf(configuration.firstClient + i), loginDue: configuration.login != ""}
+		if configuration.connCache != nil {
+			vc.http = configuration.connCache.client(ctx, r, i)
+		} else {
+			vc.http = newHTTPClient(ctx, r)
+		}
+		if configuration.cookieJar {
+			vc.jar = &cookieJar{}
+		}
+		if replaySlots != nil {
+			go replayWorker(configuration, vc, replaySlots, &done)
+		} else if slots != nil {
+			go worker(configuration, vc, slots, &done)
+		} else {
+			go client(configuration, vc, &done)
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+
+	fmt.Fprintln(configuration.log, "Waiting for results...")
+	ticker := time.NewTicker(configuration.progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if configuration.onProgress != nil {
+				configuration.onProgress(r.progress())
+			}
+			continue
+		case <-finished:
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	snapshot()
+	cancel()
+	<-finished
+	return nil
+}
+
+func (r *Runner) progress() Progress {
+	p := Progress{Elapsed: time.Since(r.started()), InFlight: atomic.LoadInt64(&r.inFlight)}
+	for _, result := range r.results {
+		result.mu.Lock()
+		p.Requests += result.requests
+		p.Success += result.success
+		p.Failed += result.networkFailed + result.badFailed + result.mismatched
+		result.mu.Unlock()
+	}
+	return p
+}
@@ -0,0 +1,142 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Log of a run, written to by all its clients at once
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{URL: server.URL + "/mc", Clients: 3, Requests: 5, Expect: "Monte"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 15 || report.Success != 15 || len(report.URLs) != 1 {
+		t.Fatalf("Wrong report %+v", report)
+	}
+	// the body of 11 bytes comes with a header
+	if received := report.URLs[0].Received; received == nil || received.Count != 15 || received.Min <= 11 ||
+		received.Total != received.Min*15 || report.URLs[0].Sent.Total == 0 {
+		t.Fatalf("Wrong payload %+v", received)
+	}
+
+	// a run without requests or period only ends with ctx
+	var calls int64
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	report, err = Run(ctx, Config{URL: server.URL + "/mc", Clients: 2, ProgressInterval: 50 * time.Millisecond,
+		OnProgress: func(p Progress) { atomic.AddInt64(&calls, 1) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || atomic.LoadInt64(&calls) == 0 {
+		t.Fatalf("Expected requests and progress, got %d requests and %d calls", report.Requests, calls)
+	}
+
+	// a period shorter than a second ends the run too
+	start := time.Now()
+	report, err = Run(context.Background(), Config{URL: server.URL + "/mc", Clients: 2, Period: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || time.Since(start) > 5*time.Second {
+		t.Fatalf("Expected requests within the period, got %d requests in %s", report.Requests, time.Since(start))
+	}
+
+	// failed responses are logged with Debug only
+	for _, debug := range []bool{false, true} {
+		var log syncBuffer
+		if _, err := Run(context.Background(), Config{URL: server.URL + "/mc", Clients: 1, Requests: 1,
+			Expect: "Sudoku", Log: &log, Debug: debug}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(log.String(), "not match: Sudoku") != debug {
+			t.Fatalf("Wrong log with debug %v: %q", debug, log.String())
+		}
+	}
+
+	if _, err := New(Config{URL: server.URL, Requests: 1, Period: time.Second}); err == nil {
+		t.Fatal("Requests and period together should be invalid")
+	} else if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("Expected a ConfigError, got %v", err)
+	}
+}
+
+func TestPoissonSeed(t *testing.T) {
+	arrivals := func(seed int64) []time.Duration {
+		configuration := &Configuration{requests: 20, clients: 1, arrivalRate: 10000, arrivalMode: "poisson",
+			seed: seed}
+		slots := make(chan time.Time, 20)
+		schedule(context.Background(), configuration, slots)
+		var gaps []time.Duration
+		var last time.Time
+		for slot := range slots {
+			if !last.IsZero() {
+				gaps = append(gaps, slot.Sub(last))
+			}
+			last = slot
+		}
+		return gaps
+	}
+
+	// the same seed gives the same arrivals, another seed others
+	first, again, other := arrivals(7), arrivals(7), arrivals(8)
+	if len(first) != 19 || len(again) != 19 || len(other) != 19 {
+		t.Fatalf("Wrong number of arrivals %d", len(first))
+	}
+	same := true
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("Arrival %d is %s then %s with the same seed", i, first[i], again[i])
+		}
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Fatal("Arrivals should differ with another seed")
+	}
+}
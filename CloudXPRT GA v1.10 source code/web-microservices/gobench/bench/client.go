@@ -0,0 +1,671 @@
+/*******************************************************************************
+* The code in file 'client.go' is based on gobench.go, found at
+* https://github.com/cmpxchg16/gobench, and licensed under New BSD License
+* by Uri Shamay (shamayuri@gmail.com).
+*
+* Additional code and modification to gobench.go are Copyright 2020 BenchmarkXPRT
+* Development Community, and licensed under Apache License, Version 2.0.
+*
+*
+* Copyright 2020 Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// State of one virtual client, only used by its own goroutine
+type virtualClient struct {
+	id     int
+	sent   int64
+	ctx    context.Context
+	run    *Runner
+	result *Result
+	errSet map[string]struct{}
+	http   *httpClient
+	rng    *rand.Rand
+	// identity from the user pool, cookies and login state
+	identity *identity
+	jar      *cookieJar
+	loginAt  time.Time
+	loginDue bool
+}
+
+type Result struct {
+	requests      int64
+	success       int64
+	networkFailed int64
+	badFailed     int64
+	mismatched    int64
+	// open-loop mode only: requests sent later than their intended time
+	delayed  int64
+	maxDelay time.Duration
+	// requests of the warm-up and cool-down windows
+	excluded [windowMeasured]excludedResult
+	// think time and pacing waits
+	waits   int64
+	waited  time.Duration
+	maxWait time.Duration
+
+	// statistics by URL or scenario step, locked so they can be merged
+	// while the client is still running
+	mu       sync.Mutex
+	urls     map[string]*urlResult
+	sessions sessionResult
+	// requests by identity and by endpoint
+	identities map[string]*identityResult
+	endpoints  map[string]*endpointResult
+	// time series buckets of the current interval by URL
+	series         map[string]*seriesBucket
+	seriesInterval int64
+}
+
+// Statistics of one URL (or scenario step) for one client
+type urlResult struct {
+	requests      int64
+	success       int64
+	networkFailed int64
+	badFailed     int64
+	mismatched    int64
+	hist          *Histogram
+	newConns      int64
+	reusedConns   int64
+	phases        [phaseCount]*Histogram
+	failures      map[string]*failureResult
+	// TLS handshakes resumed and by negotiated version and cipher suite
+	resumed    int64
+	negotiated map[string]int64
+	// sizes of the requests sent and responses received in bytes
+	sent     *Histogram
+	received *Histogram
+}
+
+// What happened to a single request
+type requestInfo struct {
+	start   time.Time
+	elapsed time.Duration
+	outcome int
+	newConn bool
+	phases  [phaseCount]time.Duration
+	valid   [phaseCount]bool
+	// TLS handshake of a new connection
+	resumed    bool
+	negotiated string
+	// status code of a bad response, class and message of a network error
+	status   int
+	errClass string
+	errText  string
+	// validation rule a mismatched response failed
+	check string
+	// size of the request and of the response, -1 without a response
+	sent     int64
+	received int64
+	// identity of the client and endpoint of the request
+	user     string
+	endpoint string
+}
+
+// Outcome of a single request
+const (
+	outcomeSuccess = iota
+	outcomeNetworkFailed
+	outcomeBadFailed
+	outcomeMismatched
+)
+
+// In the format of lower:upper
+func handleTimeThreshold(input string) (int, int, error) {
+	tokens := strings.Split(input, ":")
+	tlower, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid satisfied time threshold %s", tokens[0])
+	}
+	tupper, err := strconv.Atoi(tokens[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid tolerated time threshold %s", tokens[1])
+	}
+	if tlower >= tupper {
+		return 0, 0, fmt.Errorf("Satisfied time threshold should be smaller than tolerated [%d:%d]",
+			tlower, tupper)
+	}
+	return tlower, tupper, nil
+}
+
+func shuffle(urls []string, size int, rng *rand.Rand) {
+	for i := 0; i < size; i++ {
+		temp := rng.Intn(size)
+		urls[i], urls[temp] = urls[temp], urls[i]
+	}
+}
+
+func (configuration *Configuration) readLines(path string) (lines []string, err error) {
+
+	var file *os.File
+	var part []byte
+	var prefix bool
+	var currWeight = 0
+
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	buffer := bytes.NewBuffer(make([]byte, 0))
+	for {
+		if part, prefix, err = reader.ReadLine(); err != nil {
+			break
+		}
+		buffer.Write(part)
+		if !prefix {
+			temp := strings.TrimSpace(buffer.String())
+			if strings.HasPrefix(temp, "WEIGHT:") {
+				// Format of this line is: WEIGHT:3
+				tempWeight := strings.TrimPrefix(temp, "WEIGHT:")
+				currWeight, err = strconv.Atoi(tempWeight)
+				if err != nil {
+					return
+				}
+				buffer.Reset()
+			} else {
+				if currWeight > 0 {
+					// Get validation rules, the last part as they may contain [
+					if strings.Contains(temp, "[CHECK]") {
+						var checks []*check
+						results := strings.SplitN(temp, "[CHECK]", 2)
+						if checks, err = parseChecks(results[1]); err != nil {
+							return
+						}
+						temp = results[0]
+						configuration.checks[urlOfLine(temp)] = checks
+					}
+					// Get think time value and trim this part
+					if strings.Contains(temp, "[THINK]") {
+						var spec string
+						var think *thinkTime
+						temp, spec = cutToken(temp, "[THINK]")
+						if think, err = parseThink(spec); err != nil {
+							return
+						}
+						configuration.thinkTimes[urlOfLine(temp)] = think
+					}
+					// Get SLA rules and trim this part
+					if strings.Contains(temp, "[SLA]") {
+						var spec string
+						var rules []*slaRule
+						temp, spec = cutToken(temp, "[SLA]")
+						if rules, err = parseSLA(spec); err != nil {
+							return
+						}
+						configuration.slaRules[urlKey(urlOfLine(temp))] = rules
+					}
+					// Get time threshold value and trim this part
+					if strings.Contains(temp, "[THOLD]") {
+						results := strings.Split(temp, "[THOLD]")
+						configuration.thresholds[urlKey(urlOfLine(temp))] = results[1]
+						temp = results[0]
+					}
+					configuration.debugf("%s is attached %d\n", temp, currWeight)
+					for i := 0; i < currWeight; i++ {
+						lines = append(lines, temp)
+					}
+				}
+				buffer.Reset()
+			}
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	shuffle(lines, len(lines), rand.New(rand.NewSource(configuration.seed)))
+	return
+}
+
+// Sleep until t, false when ctx is done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Account for one request of key in result
+func (r *Runner) updateResult(result *Result, key string, info *requestInfo) {
+	result.mu.Lock()
+	r.recordSeries(result, key, info)
+	if w := r.window.of(info.start); w != windowMeasured {
+		result.excluded[w].add(info.outcome)
+		result.mu.Unlock()
+		return
+	}
+	if result.urls == nil {
+		result.urls = make(map[string]*urlResult)
+	}
+	stats, ok := result.urls[key]
+	if !ok {
+		stats = &urlResult{hist: NewHistogram()}
+		result.urls[key] = stats
+	}
+	stats.hist.Record(int64(info.elapsed / time.Microsecond))
+
+	if info.newConn {
+		stats.newConns++
+	} else if info.outcome != outcomeNetworkFailed {
+		stats.reusedConns++
+	}
+	for i := range info.phases {
+		if info.valid[i] {
+			if stats.phases[i] == nil {
+				stats.phases[i] = NewHistogram()
+			}
+			stats.phases[i].Record(int64(info.phases[i] / time.Microsecond))
+		}
+	}
+	if stats.sent == nil {
+		stats.sent, stats.received = NewHistogram(), NewHistogram()
+	}
+	stats.sent.Record(info.sent)
+	if info.received >= 0 {
+		stats.received.Record(info.received)
+	}
+	if info.valid[phaseTLS] {
+		if info.resumed {
+			stats.resumed++
+		}
+		if stats.negotiated == nil {
+			stats.negotiated = make(map[string]int64)
+		}
+		stats.negotiated[info.negotiated]++
+	}
+
+	if info.user != "" {
+		if result.identities == nil {
+			result.identities = make(map[string]*identityResult)
+		}
+		counts, ok := result.identities[info.user]
+		if !ok {
+			counts = &identityResult{}
+			result.identities[info.user] = counts
+		}
+		counts.requests++
+		if info.outcome != outcomeSuccess {
+			counts.failed++
+		}
+	}
+	if info.endpoint != "" {
+		if result.endpoints == nil {
+			result.endpoints = make(map[string]*endpointResult)
+		}
+		counts, ok := result.endpoints[info.endpoint]
+		if !ok {
+			counts = &endpointResult{hist: NewHistogram()}
+			result.endpoints[info.endpoint] = counts
+		}
+		counts.requests++
+		if info.outcome != outcomeSuccess {
+			counts.failed++
+		}
+		counts.hist.Record(int64(info.elapsed / time.Microsecond))
+	}
+
+	// total request number always increase by one here
+	result.requests++
+	stats.requests++
+	seen := info.start.Add(info.elapsed)
+	switch info.outcome {
+	case outcomeSuccess:
+		result.success++
+		stats.success++
+	case outcomeNetworkFailed:
+		result.networkFailed++
+		stats.networkFailed++
+		stats.addFailure(failureNetwork, info.errClass, seen, info.errText)
+	case outcomeBadFailed:
+		result.badFailed++
+		stats.badFailed++
+		stats.addFailure(failureHTTP, strconv.Itoa(info.status), seen, "")
+	case outcomeMismatched:
+		result.mismatched++
+		stats.mismatched++
+		if info.check != "" {
+			stats.addFailure(failureCheck, info.check, seen, "")
+		} else {
+			stats.addFailure(failureMismatch, "", seen, "")
+		}
+	}
+	result.mu.Unlock()
+}
+
+func setCommonHeaders(configuration *Configuration, vc *virtualClient, req *fasthttp.Request) {
+	if configuration.keepAlive == true {
+		req.Header.Set("Connection", "keep-alive")
+	} else {
+		req.Header.Set("Connection", "close")
+	}
+
+	// Add set cookie, for example usrId=6
+	if len(configuration.cookieHeader) > 0 {
+		temp := strings.Split(configuration.expandVars(configuration.cookieHeader, vc, nil), "=")
+		req.Header.SetCookie(temp[0], temp[1])
+	}
+
+	if len(configuration.authHeader) > 0 {
+		req.Header.Set("Authorization", configuration.expandVars(configuration.authHeader, vc, nil))
+	}
+}
+
+// Size of a request or response in bytes, header and body as sent over
+// HTTP/1.1. TLS and HTTP/2 framing only show in the read and write
+// throughput of the whole run.
+func messageSize(header interface{ Header() []byte }, body []byte) int64 {
+	return int64(len(header.Header()) + len(body))
+}
+
+// Send req and account for it in the client result under key, with the
+// response time measured from start. The response is checked for pattern
+// and the validation rules checks. It is returned unless the request failed
+// on the network and has to be released by the caller.
+func sendRequest(configuration *Configuration, vc *virtualClient, key string, req *fasthttp.Request,
+	pattern []byte, checks []*check, start time.Time) (*fasthttp.Response, bool) {
+
+	// waiting for a pooled connection is part of the response time
+	http, release := vc.acquireHTTP()
+	if http == nil {
+		return nil, false
+	}
+	vc.run.churnConn(req)
+	if vc.jar != nil {
+		vc.jar.apply(req)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	vc.sent++
+	atomic.AddInt64(&vc.run.inFlight, 1)
+	err := http.Do(req, resp)
+	atomic.AddInt64(&vc.run.inFlight, -1)
+
+	info := &requestInfo{start: start, elapsed: time.Since(start), newConn: http.trace.dialed,
+		sent: messageSize(&req.Header, req.Body()), received: -1}
+	info.resumed, info.negotiated = http.trace.resumed, http.trace.negotiated
+	info.phases, info.valid = http.phases()
+	info.endpoint = http.endpoint
+	release()
+	if vc.identity != nil {
+		info.user = vc.identity.name
+	}
+
+	if err != nil {
+		// cut off by the end of the run (h2 and h2c), not a failure
+		if vc.ctx.Err() != nil {
+			fasthttp.ReleaseResponse(resp)
+			return nil, false
+		}
+		if _, ok := vc.errSet[err.Error()]; !ok {
+			vc.errSet[err.Error()] = struct{}{}
+			// debug only
+			fmt.Fprintln(configuration.log, err.Error())
+		}
+		info.outcome = outcomeNetworkFailed
+		info.errClass, info.errText = classifyError(err), err.Error()
+		vc.run.updateResult(vc.result, key, info)
+		fasthttp.ReleaseResponse(resp)
+		return nil, false
+	}
+
+	info.received = messageSize(&resp.Header, resp.Body())
+	statusCode := resp.StatusCode()
+	if vc.jar != nil {
+		vc.jar.update(req.URI(), resp)
+	}
+	if statusCode == fasthttp.StatusUnauthorized {
+		vc.loginDue = true
+	}
+
+	if statusCode != fasthttp.StatusOK && !checksStatus(checks) {
+		configuration.debugf("%s: status %d\n", key, statusCode)
+		info.outcome = outcomeBadFailed
+		info.status = statusCode
+		vc.run.updateResult(vc.result, key, info)
+		return resp, false
+	}
+
+	if len(pattern) > 0 && !bytes.Contains(resp.Body(), pattern) {
+		configuration.debugf("%s: not match: %s\n", key, pattern)
+		info.outcome = outcomeMismatched
+		vc.run.updateResult(vc.result, key, info)
+		return resp, false
+	}
+
+	if failed := validate(checks, resp); failed != nil {
+		configuration.debugf("%s: failed rule: %s\n", key, failed.text)
+		info.outcome = outcomeMismatched
+		info.check = failed.text
+		vc.run.updateResult(vc.result, key, info)
+		return resp, false
+	}
+
+	info.outcome = outcomeSuccess
+	vc.run.updateResult(vc.result, key, info)
+	return resp, true
+}
+
+// Key of the results of a URL of the URL list, as doRequest reports them:
+// the URL as fasthttp sends it, for example with a / for a URL without a
+// path, unless it is a template
+func urlKey(url string) string {
+	if strings.Contains(url, "${") {
+		return url
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(url)
+	return req.URI().String()
+}
+
+// Send one request of the URL list. Response time is measured from start,
+// which is the intended send time in open-loop mode.
+func doRequest(configuration *Configuration, vc *virtualClient, tmpURL string, start time.Time) {
+	vc.login(configuration)
+
+	// expected contents from response
+	pattern := make([]byte, 0, 256)
+
+	req := fasthttp.AcquireRequest()
+
+	if strings.Contains(tmpURL, "[EXPECT]") {
+		result := strings.Split(tmpURL, "[EXPECT]")
+		pattern = []byte(configuration.expandVars(result[1], vc, nil))
+		tmpURL = result[0]
+	} else if len(configuration.expect) > 0 {
+		pattern = []byte(configuration.expandVars(configuration.expect, vc, nil))
+	}
+
+	// requests of a templated URL are reported under the template
+	template := urlOfLine(tmpURL)
+	if strings.Contains(tmpURL, "[POST]") {
+		result := strings.Split(tmpURL, "[POST]")
+		req.SetRequestURI(configuration.expandVars(result[0], vc, nil))
+		req.Header.SetMethodBytes([]byte("POST"))
+		req.SetBodyString(configuration.expandVars(result[1], vc, nil))
+	} else {
+		req.SetRequestURI(configuration.expandVars(tmpURL, vc, nil))
+		req.Header.SetMethodBytes([]byte("GET"))
+		if bytes.Contains(configuration.postData, []byte("${")) {
+			req.SetBodyString(configuration.expandVars(string(configuration.postData), vc, nil))
+		} else {
+			req.SetBody(configuration.postData)
+		}
+	}
+
+	setCommonHeaders(configuration, vc, req)
+
+	key := req.URI().String()
+	if strings.Contains(template, "${") {
+		key = template
+	}
+	checks := configuration.check
+	if urlChecks, ok := configuration.checks[template]; ok {
+		checks = urlChecks
+	}
+	resp, _ := sendRequest(configuration, vc, key, req, pattern, checks, start)
+	if resp != nil {
+		fasthttp.ReleaseResponse(resp)
+	}
+	fasthttp.ReleaseRequest(req)
+}
+
+func client(configuration *Configuration, vc *virtualClient, done *sync.WaitGroup) {
+	defer done.Done()
+
+	// spread the start of the clients over the ramp
+	if configuration.ramp > 0 {
+		offset := configuration.ramp * time.Duration(vc.id) / time.Duration(configuration.clients)
+		if !sleepUntil(vc.ctx, time.Now().Add(offset)) {
+			return
+		}
+	}
+
+	for vc.sent < configuration.requests && vc.ctx.Err() == nil {
+		if configuration.scenario != nil {
+			start := time.Now()
+			runScenario(configuration, vc, start)
+			pause(configuration, vc, nil, start)
+			continue
+		}
+		for _, tmpURL := range configuration.urls {
+			// not a valid URL, ignore it
+			if len(tmpURL) < 10 {
+				continue
+			}
+			if vc.ctx.Err() != nil {
+				return
+			}
+			start := time.Now()
+			doRequest(configuration, vc, tmpURL, start)
+
+			think := configuration.think
+			if urlThink, ok := configuration.thinkTimes[urlOfLine(tmpURL)]; ok {
+				think = urlThink
+			}
+			pause(configuration, vc, think, start)
+		}
+	}
+}
+
+// Open-loop scheduler: emit the intended send time of every request at the
+// target arrival rate, no matter how long the responses take. Intended times
+// are derived from the schedule, so a backlog of busy workers shows up as
+// response time instead of silently lowering the offered load.
+func schedule(ctx context.Context, configuration *Configuration, slots chan<- time.Time) {
+	defer close(slots)
+	total := configuration.requests
+	if configuration.requests != unlimited {
+		total = configuration.requests * int64(configuration.clients)
+	}
+
+	interval := float64(time.Second) / configuration.arrivalRate
+	rng := rand.New(rand.NewSource(configuration.seed))
+	start := time.Now()
+	var offset time.Duration
+	for i := int64(0); i < total; i++ {
+		next := start.Add(rampOffset(offset, configuration.ramp))
+		if !sleepUntil(ctx, next) {
+			return
+		}
+		select {
+		case slots <- next:
+		case <-ctx.Done():
+			return
+		}
+
+		if configuration.arrivalMode == "poisson" {
+			offset += time.Duration(rng.ExpFloat64() * interval)
+		} else {
+			offset += time.Duration(interval)
+		}
+	}
+}
+
+// Open-loop client: take the next scheduled slot and send the next URL of
+// the list for it. Clients only bound the number of requests in flight.
+func worker(configuration *Configuration, vc *virtualClient, slots <-chan time.Time, done *sync.WaitGroup) {
+	defer done.Done()
+
+	var index = 0
+	for intended := range slots {
+		if vc.ctx.Err() != nil {
+			return
+		}
+
+		// every slot starts a new session of the scenario
+		if configuration.scenario != nil {
+			vc.run.updateDelay(vc.result, intended)
+			runScenario(configuration, vc, intended)
+			continue
+		}
+
+		// not a valid URL, ignore it
+		tmpURL := ""
+		for i := 0; i < len(configuration.urls) && len(tmpURL) < 10; i++ {
+			tmpURL = configuration.urls[index%len(configuration.urls)]
+			index++
+		}
+		if len(tmpURL) < 10 {
+			continue
+		}
+
+		vc.run.updateDelay(vc.result, intended)
+		doRequest(configuration, vc, tmpURL, intended)
+	}
+}
+
+func (r *Runner) updateDelay(result *Result, intended time.Time) {
+	if r.window.of(intended) != windowMeasured {
+		return
+	}
+	delay := time.Since(intended)
+	result.mu.Lock()
+	if delay > time.Millisecond {
+		result.delayed++
+	}
+	if delay > result.maxDelay {
+		result.maxDelay = delay
+	}
+	result.mu.Unlock()
+}
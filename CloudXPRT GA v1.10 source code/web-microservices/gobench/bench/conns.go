@@ -0,0 +1,130 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Connection modes of HTTP/1.1. Every virtual client keeps its own
+// connection per host (client), the clients share a pool of PoolSize
+// connections per host and wait for a free one (pool), or every request
+// opens a new connection (request). On top of client and pool, a churn rate
+// asks the servers to close that many connections per second after their
+// next response, like servers and proxies limiting the requests of a
+// keep-alive connection.
+const (
+	connClient  = "client"
+	connPool    = "pool"
+	connRequest = "request"
+)
+
+// Count a connection event of the measured window
+func (r *Runner) countConn(counter *int64) {
+	if r.window.of(time.Now()) == windowMeasured {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+// Connections shared by the clients in pool mode, a pool entry is an
+// httpClient with one connection per host so the trace of the request in
+// flight belongs to the entry
+func (r *Runner) newPool(ctx context.Context) {
+	r.pool = make(chan *httpClient, r.configuration.poolSize)
+	for i := 0; i < r.configuration.poolSize; i++ {
+		r.pool <- newHTTPClient(ctx, r)
+	}
+}
+
+// The HTTP client for the next request of vc and the function releasing
+// it, nil when the run ended while waiting for a pooled connection
+func (vc *virtualClient) acquireHTTP() (*httpClient, func()) {
+	pool := vc.run.pool
+	if pool == nil {
+		return vc.http, func() {}
+	}
+	select {
+	case c := <-pool:
+		return c, func() { pool <- c }
+	case <-vc.ctx.Done():
+		return nil, nil
+	}
+}
+
+// Hand out close requests at the churn rate until ctx is done. Requests
+// not taken are dropped, so an idle period does not close a burst of
+// connections later.
+func (r *Runner) churnConns(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.configuration.connChurn))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case r.churn <- struct{}{}:
+			default:
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ask the server to close the connection of req after its response when a
+// close request is due
+func (r *Runner) churnConn(req *fasthttp.Request) {
+	if r.churn == nil {
+		return
+	}
+	select {
+	case <-r.churn:
+		req.SetConnectionClose()
+	default:
+	}
+}
+
+// Clients kept with their open connections from one run to the next, so
+// runs following each other like the steps of autoloader skip the dials and
+// handshakes of a cold start. A run in client mode takes over the clients
+// of the previous run, adds the missing ones and leaves all in the cache
+// when it ends. Runs sharing a cache must not overlap and should have the
+// same connection options. Connections left idle close after the idle
+// timeout of fasthttp.
+type ConnCache struct {
+	mu      sync.Mutex
+	clients []*httpClient
+}
+
+// The HTTP client of virtual client i of r, from the cache when it has one
+func (cache *ConnCache) client(ctx context.Context, r *Runner, i int) *httpClient {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if i >= len(cache.clients) {
+		c := newHTTPClient(ctx, r)
+		cache.clients = append(cache.clients, c)
+		return c
+	}
+	c := cache.clients[i]
+	c.ctx, c.run, c.configuration = ctx, r, r.configuration
+	c.owner.run.Store(r)
+	return c
+}
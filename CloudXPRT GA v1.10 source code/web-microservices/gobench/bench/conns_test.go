@@ -0,0 +1,119 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestConnModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	expected := []struct {
+		cfg    Config
+		opened int64
+	}{
+		{Config{ConnMode: connClient}, 4},
+		{Config{ConnMode: connPool, PoolSize: 2}, 2},
+		{Config{DisableKeepAlive: true}, 40},
+	}
+	for _, e := range expected {
+		cfg := e.cfg
+		cfg.URL, cfg.Clients, cfg.Requests = server.URL+"/mc", 4, 10
+		report, err := Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Success != 40 || report.Connections.Opened != e.opened || report.NewConns != e.opened {
+			t.Fatalf("Wrong connections in %s mode: %+v, %d new", report.Config.ConnMode,
+				report.Connections, report.NewConns)
+		}
+	}
+
+	for _, cfg := range []Config{{ConnMode: "shared"}, {ConnMode: connPool}, {PoolSize: 2},
+		{DisableKeepAlive: true, ConnChurn: 1}, {Protocol: protoH2C, ConnMode: connRequest}} {
+		cfg.URL, cfg.Requests = server.URL, 1
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+}
+
+func TestConnCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	// the runs take over the connections of the clients before them
+	cache := &ConnCache{}
+	for _, e := range []struct {
+		clients int
+		opened  int64
+	}{{2, 2}, {3, 1}, {2, 0}} {
+		report, err := Run(context.Background(), Config{URL: server.URL + "/mc", Clients: e.clients, Requests: 3,
+			ConnCache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Success != int64(3*e.clients) || report.Connections.Opened != e.opened || report.NewConns != e.opened {
+			t.Fatalf("Wrong connections of %d cached clients: %+v, %d new", e.clients, report.Connections,
+				report.NewConns)
+		}
+	}
+
+	for _, cfg := range []Config{{ConnCache: cache, Protocol: protoH2C}, {ConnCache: cache, DisableKeepAlive: true}} {
+		cfg.URL, cfg.Requests = server.URL, 1
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+}
+
+func TestH2ClosedConns(t *testing.T) {
+	// the server closes every connection idle for longer than the pace
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}), &http2.Server{IdleTimeout: 50 * time.Millisecond}))
+	defer server.Close()
+
+	runner, err := New(Config{URL: server.URL + "/mc", Clients: 1, Requests: 4, Protocol: protoH2C,
+		Pace: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.h2.Lock()
+	conns := len(runner.h2.conns)
+	runner.h2.Unlock()
+	if report.Success != 4 || report.Connections.Opened != 4 || conns > 1 {
+		t.Fatalf("Wrong connections: %+v, %d kept", report.Connections, conns)
+	}
+}
@@ -0,0 +1,197 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Failures of a URL are counted by kind: "http" with the status code for
+// responses other than 200, "network" with a class of the error for requests
+// without a response, "mismatch" for 200 responses without the expected
+// pattern and "check" with the rule for responses failing a validation rule.
+const (
+	failureHTTP     = "http"
+	failureNetwork  = "network"
+	failureMismatch = "mismatch"
+	failureCheck    = "check"
+)
+
+// One kind of failure of one URL
+type failureResult struct {
+	kind   string
+	detail string
+	count  int64
+	first  time.Time
+	last   time.Time
+	sample string
+}
+
+// Class of a network error, fasthttp wraps most errors in plain strings so
+// the message is checked when the type does not tell
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+
+	switch {
+	case errors.Is(err, fasthttp.ErrTimeout), errors.Is(err, fasthttp.ErrTLSHandshakeTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, fasthttp.ErrNoFreeConns):
+		return "no_free_conns"
+	case errors.Is(err, fasthttp.ErrConnectionClosed), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "closed"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &hostErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "timeout"), strings.Contains(message, "timed out"):
+		return "timeout"
+	case strings.Contains(message, "connection refused"):
+		return "refused"
+	case strings.Contains(message, "connection reset"), strings.Contains(message, "broken pipe"):
+		return "reset"
+	case strings.Contains(message, "server closed connection"), strings.Contains(message, "EOF"):
+		return "closed"
+	case strings.Contains(message, "no such host"):
+		return "dns"
+	case strings.Contains(message, "tls:"), strings.Contains(message, "x509:"):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+func failureKey(kind string, detail string) string {
+	if detail == "" {
+		return kind
+	}
+	return kind + ":" + detail
+}
+
+// Account for a failure seen at t, sample is the first error message
+func (stats *urlResult) addFailure(kind string, detail string, t time.Time, sample string) {
+	key := failureKey(kind, detail)
+	if stats.failures == nil {
+		stats.failures = make(map[string]*failureResult)
+	}
+	failure, ok := stats.failures[key]
+	if !ok {
+		failure = &failureResult{kind: kind, detail: detail, first: t, sample: sample}
+		stats.failures[key] = failure
+	}
+	failure.count++
+	if t.Before(failure.first) {
+		failure.first = t
+	}
+	if t.After(failure.last) {
+		failure.last = t
+	}
+}
+
+func (stats *urlResult) mergeFailures(other map[string]*failureResult) {
+	for key, failure := range other {
+		if stats.failures == nil {
+			stats.failures = make(map[string]*failureResult)
+		}
+		merged, ok := stats.failures[key]
+		if !ok {
+			copied := *failure
+			stats.failures[key] = &copied
+			continue
+		}
+		merged.count += failure.count
+		if failure.first.Before(merged.first) {
+			merged.first = failure.first
+			merged.sample = failure.sample
+		}
+		if failure.last.After(merged.last) {
+			merged.last = failure.last
+		}
+	}
+}
+
+// Failures of a URL for the report, the most frequent first
+func failureReports(failures map[string]*failureResult) []Failure {
+	reports := make([]Failure, 0, len(failures))
+	for _, failure := range failures {
+		report := Failure{Kind: failure.kind, Count: failure.count,
+			FirstSeen: failure.first, LastSeen: failure.last, Sample: failure.sample}
+		if failure.kind == failureHTTP {
+			report.Status, _ = strconv.Atoi(failure.detail)
+		} else {
+			report.Reason = failure.detail
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Count != reports[j].Count {
+			return reports[i].Count > reports[j].Count
+		}
+		return reports[i].name() < reports[j].name()
+	})
+	return reports
+}
+
+// Short name of a failure such as http 503 or network timeout
+func (f *Failure) name() string {
+	switch {
+	case f.Status != 0:
+		return f.Kind + " " + strconv.Itoa(f.Status)
+	case f.Reason != "":
+		return f.Kind + " " + f.Reason
+	default:
+		return f.Kind
+	}
+}
+
+// Failures in the report back to the form kept by clients, for agents
+func failureResults(reports []Failure) map[string]*failureResult {
+	failures := make(map[string]*failureResult)
+	for _, report := range reports {
+		detail := report.Reason
+		if report.Kind == failureHTTP {
+			detail = strconv.Itoa(report.Status)
+		}
+		failures[failureKey(report.Kind, detail)] = &failureResult{kind: report.Kind, detail: detail,
+			count: report.Count, first: report.FirstSeen, last: report.LastSeen, sample: report.Sample}
+	}
+	return failures
+}
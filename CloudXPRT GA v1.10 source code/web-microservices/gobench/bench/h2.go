@@ -0,0 +1,282 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// Protocols of a run. fasthttp only speaks HTTP/1.1, so h2 (HTTP/2 over TLS,
+// https URLs) and h2c (cleartext HTTP/2 with prior knowledge, http URLs) go
+// through the transport of golang.org/x/net/http2. Requests and responses
+// are converted from and to fasthttp, so everything else is the same for
+// all protocols.
+const (
+	protoHTTP1 = "http1"
+	protoH2    = "h2"
+	protoH2C   = "h2c"
+)
+
+// Unlike HTTP/1.1 connections, which belong to one client, the clients of
+// a run share its h2conns transports so their requests are multiplexed as
+// concurrent streams. A transport opens one connection per host, or more
+// when the server limits the concurrent streams. Connections that failed,
+// closed or got a GOAWAY of the server leave its pool and the run.
+type h2State struct {
+	transports []*http2.Transport
+	next       int64
+
+	sync.Mutex
+	conns map[net.Conn]*h2Conn
+}
+
+// A connection of an HTTP/2 transport. How it was set up is reported with
+// the request that opened it, like dial and handshake of HTTP/1.1.
+type h2Conn struct {
+	dial       time.Duration
+	handshake  time.Duration
+	resumed    bool
+	negotiated string
+	opened     bool
+	streams    int64
+}
+
+func newH2State(run *Runner) *h2State {
+	configuration := run.configuration
+	state := &h2State{conns: make(map[net.Conn]*h2Conn)}
+	for i := 0; i < configuration.h2Transports; i++ {
+		transport := &http2.Transport{AllowHTTP: configuration.protocol == protoH2C}
+		pool := &h2Pool{run: run, transport: transport, conns: make(map[string][]*http2.ClientConn),
+			netConns: make(map[*http2.ClientConn]net.Conn)}
+		if configuration.tlsResume {
+			pool.sessions = tls.NewLRUClientSessionCache(0)
+		}
+		transport.ConnPool = pool
+		state.transports = append(state.transports, transport)
+	}
+	return state
+}
+
+// Connections of a transport by address. Unlike the default pool of the
+// transport it knows the connection of the run under every ClientConn.
+type h2Pool struct {
+	run       *Runner
+	transport *http2.Transport
+	sessions  tls.ClientSessionCache
+
+	mu       sync.Mutex
+	conns    map[string][]*http2.ClientConn
+	netConns map[*http2.ClientConn]net.Conn
+}
+
+func (p *h2Pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			return cc, nil
+		}
+	}
+	conn, err := dialH2(p.run, addr, p.sessions)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.transport.NewClientConn(conn)
+	if err != nil {
+		p.forget(conn)
+		conn.Close()
+		return nil, err
+	}
+	p.conns[addr] = append(p.conns[addr], cc)
+	p.netConns[cc] = conn
+	return cc, nil
+}
+
+// Called by the transport when cc can take no more requests
+func (p *h2Pool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.netConns[cc]
+	if !ok {
+		return
+	}
+	delete(p.netConns, cc)
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i:i], conns[i+1:]...)
+				break
+			}
+		}
+		if len(p.conns[addr]) == 0 {
+			delete(p.conns, addr)
+		}
+	}
+	p.forget(conn)
+}
+
+// Remove the state of conn from the run
+func (p *h2Pool) forget(conn net.Conn) {
+	p.run.h2.Lock()
+	delete(p.run.h2.conns, conn)
+	p.run.h2.Unlock()
+}
+
+func dialH2(run *Runner, addr string, sessions tls.ClientSessionCache) (net.Conn, error) {
+	configuration := run.configuration
+	start := time.Now()
+	conn, err := configuration.dial(addr, configuration.protocol == protoH2, configuration.writeTimeout)
+	if err != nil {
+		run.countConn(&run.connErrors)
+		return nil, err
+	}
+	run.countConn(&run.connsOpened)
+	state := &h2Conn{dial: time.Since(start)}
+	myConn := &MyConn{Conn: conn, owner: newConnOwner(run)}
+	var h2conn net.Conn = myConn
+
+	if configuration.protocol == protoH2 {
+		tlsConfig := configuration.tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+		tlsConfig.ClientSessionCache = sessions
+		if len(tlsConfig.ServerName) == 0 {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		start = time.Now()
+		tlsConn := tls.Client(h2conn, tlsConfig)
+		tlsConn.SetDeadline(start.Add(configuration.writeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			run.countConn(&run.connErrors)
+			myConn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		state.handshake = time.Since(start)
+
+		tlsState := tlsConn.ConnectionState()
+		if tlsState.NegotiatedProtocol != http2.NextProtoTLS {
+			run.countConn(&run.connErrors)
+			myConn.Close()
+			return nil, fmt.Errorf("tls: server did not negotiate h2 (got %q)", tlsState.NegotiatedProtocol)
+		}
+		state.resumed = tlsState.DidResume
+		state.negotiated = tlsVersionName(tlsState.Version) + " " + tls.CipherSuiteName(tlsState.CipherSuite)
+		h2conn = tlsConn
+	}
+
+	run.h2.Lock()
+	run.h2.conns[h2conn] = state
+	run.h2.Unlock()
+	return h2conn, nil
+}
+
+// Do req over HTTP/2 and fill the trace of the client like an HTTP/1.1
+// request would
+func (c *httpClient) doH2(req *fasthttp.Request, resp *fasthttp.Response, target *endpoint) error {
+	uri := req.URI()
+	scheme := string(uri.Scheme())
+	if (c.configuration.protocol == protoH2 && scheme != "https") ||
+		(c.configuration.protocol == protoH2C && scheme != "http") {
+		return fmt.Errorf("unsupported protocol %q. %s needs %s", scheme, c.configuration.protocol,
+			map[string]string{protoH2: "https", protoH2C: "http"}[c.configuration.protocol])
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.configuration.writeTimeout+c.configuration.readTimeout)
+	defer cancel()
+
+	var state *h2Conn
+	trace := &c.trace
+	clientTrace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.run.h2.Lock()
+			state = c.run.h2.conns[info.Conn]
+			if state != nil && !state.opened {
+				state.opened = true
+				trace.dialed, trace.dial, trace.handshake = true, state.dial, state.handshake
+				trace.resumed, trace.negotiated = state.resumed, state.negotiated
+			}
+			c.run.h2.Unlock()
+			if state != nil {
+				streams := atomic.AddInt64(&state.streams, 1)
+				for max := atomic.LoadInt64(&c.run.h2MaxStreams); streams > max; max = atomic.LoadInt64(&c.run.h2MaxStreams) {
+					if atomic.CompareAndSwapInt64(&c.run.h2MaxStreams, max, streams) {
+						break
+					}
+				}
+			}
+		},
+	}
+	if trace.timing {
+		clientTrace.WroteRequest = func(httptrace.WroteRequestInfo) { trace.written = time.Now() }
+		clientTrace.GotFirstResponseByte = func() { trace.firstByte = time.Now() }
+	}
+
+	httpReq, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, clientTrace),
+		string(req.Header.Method()), uri.String(), bytes.NewReader(req.Body()))
+	if err != nil {
+		return err
+	}
+	// the transport connects to the host of the URL
+	if target != nil {
+		httpReq.URL.Host = target.addr
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case fasthttp.HeaderHost:
+			httpReq.Host = string(value)
+		case fasthttp.HeaderContentLength, fasthttp.HeaderConnection:
+		default:
+			httpReq.Header.Add(string(key), string(value))
+		}
+	})
+
+	httpResp, err := c.run.h2.transports[c.h2].RoundTrip(httpReq)
+	if state != nil {
+		defer atomic.AddInt64(&state.streams, -1)
+	}
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	resp.SetStatusCode(httpResp.StatusCode)
+	for key, values := range httpResp.Header {
+		for _, value := range values {
+			resp.Header.Add(key, value)
+		}
+	}
+	resp.SetBody(body)
+	return nil
+}
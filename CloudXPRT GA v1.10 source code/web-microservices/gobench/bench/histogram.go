@@ -0,0 +1,225 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+)
+
+// Values below 2^histSubBits are counted exactly, above that every power of
+// two range is split into 2^(histSubBits-1) buckets. A value is reported as
+// the top of its bucket, so the relative error of a value at the low edge of
+// a bucket is up to 1/2^(histSubBits-1), below 1.6%.
+const (
+	histSubBits  = 7
+	histSubCount = 1 << histSubBits
+	histHalf     = histSubCount / 2
+)
+
+// Histogram is a log-linear (HDR style) histogram of response times in
+// microseconds. It has a small fixed cost per bucket in use, so it can be
+// kept per URL and per client and merged when the run is over.
+type Histogram struct {
+	counts []int64
+	total  int64
+	min    int64
+	max    int64
+	sum    float64
+	sumSq  float64
+}
+
+// Serialized form of a histogram, buckets are [lowest value, count] pairs.
+type histogramJSON struct {
+	Unit    string     `json:"unit"`
+	Count   int64      `json:"count"`
+	Min     int64      `json:"min"`
+	Max     int64      `json:"max"`
+	Sum     float64    `json:"sum"`
+	SumSq   float64    `json:"sumsq"`
+	Buckets [][2]int64 `json:"buckets"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histIndex(value int64) int {
+	shift := bits.Len64(uint64(value)) - histSubBits
+	if shift < 0 {
+		shift = 0
+	}
+	return shift*histHalf + int(value>>uint(shift))
+}
+
+// Lowest and highest value counted by bucket index
+func histRange(index int) (int64, int64) {
+	if index < histSubCount {
+		return int64(index), int64(index)
+	}
+	shift := index/histHalf - 1
+	low := int64(index-shift*histHalf) << uint(shift)
+	return low, low + (1 << uint(shift)) - 1
+}
+
+func (h *Histogram) add(value int64, count int64) {
+	if value < 0 {
+		value = 0
+	}
+	index := histIndex(value)
+	if index >= len(h.counts) {
+		counts := make([]int64, index+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[index] += count
+	if h.total == 0 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.total += count
+}
+
+// Record one response time in microseconds
+func (h *Histogram) Record(value int64) {
+	h.add(value, 1)
+	h.sum += float64(value)
+	h.sumSq += float64(value) * float64(value)
+}
+
+func (h *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.total += other.total
+	h.sum += other.sum
+	h.sumSq += other.sumSq
+}
+
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+func (h *Histogram) Min() int64 {
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+func (h *Histogram) Sum() float64 {
+	return h.sum
+}
+
+func (h *Histogram) StdDev() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	mean := h.Mean()
+	variance := h.sumSq/float64(h.total) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// Value at the given percentile (0-100), reported as the highest value of
+// its bucket but never above the largest value recorded
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= target {
+			_, high := histRange(i)
+			if high > h.max {
+				high = h.max
+			}
+			if high < h.min {
+				high = h.min
+			}
+			return high
+		}
+	}
+	return h.max
+}
+
+// Number of recorded values in buckets at or below value
+func (h *Histogram) CountAtOrBelow(value int64) int64 {
+	last := histIndex(value)
+	var count int64
+	for i := 0; i < len(h.counts) && i <= last; i++ {
+		count += h.counts[i]
+	}
+	return count
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	out := histogramJSON{Unit: "us", Count: h.total, Min: h.min, Max: h.max,
+		Sum: h.sum, SumSq: h.sumSq, Buckets: make([][2]int64, 0)}
+	for i, count := range h.counts {
+		if count > 0 {
+			low, _ := histRange(i)
+			out.Buckets = append(out.Buckets, [2]int64{low, count})
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var in histogramJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*h = Histogram{}
+	for _, bucket := range in.Buckets {
+		h.add(bucket[0], bucket[1])
+	}
+	h.min, h.max, h.sum, h.sumSq = in.Min, in.Max, in.Sum, in.SumSq
+	return nil
+}
@@ -0,0 +1,88 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	hist := NewHistogram()
+	for v := int64(1); v <= 100000; v++ {
+		hist.Record(v)
+	}
+	if hist.Count() != 100000 || hist.Min() != 1 || hist.Max() != 100000 {
+		t.Fatal("Histogram count, min or max is wrong.")
+	}
+	for _, pct := range []float64{50, 90, 99, 99.9} {
+		expected := pct * 1000
+		value := float64(hist.ValueAtPercentile(pct))
+		if value < expected || value > expected*1.01 {
+			t.Fatalf("Percentile %v is %v, expected within 1%% of %v.", pct, value, expected)
+		}
+	}
+	if hist.ValueAtPercentile(100) != 100000 {
+		t.Fatal("Max percentile should be the max value.")
+	}
+}
+
+func TestHistogramBucketEdges(t *testing.T) {
+	// 8192 us starts a bucket of 128 values and is reported as its top
+	hist := NewHistogram()
+	hist.Record(8192)
+	hist.Record(1 << 30)
+	if value := hist.ValueAtPercentile(50); value != 8319 {
+		t.Fatalf("Percentile 50 is %v, expected the top of the bucket of 8192", value)
+	}
+
+	for index := histSubCount; index < 24*histHalf; index++ {
+		low, _ := histRange(index)
+		hist := NewHistogram()
+		hist.Record(low)
+		hist.Record(1 << 30)
+		value := hist.ValueAtPercentile(50)
+		if value < low || float64(value-low) >= 0.016*float64(low) {
+			t.Fatalf("Percentile 50 is %v, expected within 1.6%% of %v.", value, low)
+		}
+	}
+}
+
+func TestHistogramMergeAndSerialize(t *testing.T) {
+	first, second := NewHistogram(), NewHistogram()
+	for v := int64(0); v < 1000; v++ {
+		first.Record(v)
+		second.Record(v + 1000)
+	}
+	first.Merge(second)
+
+	data, err := json.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewHistogram()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != 2000 || decoded.Min() != 0 || decoded.Max() != 1999 {
+		t.Fatal("Decoded histogram count, min or max is wrong.")
+	}
+	if decoded.ValueAtPercentile(50) != first.ValueAtPercentile(50) ||
+		decoded.Mean() != first.Mean() || decoded.StdDev() != first.StdDev() {
+		t.Fatal("Decoded histogram does not match the merged one.")
+	}
+}
@@ -0,0 +1,258 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// A user pool file gives every client an identity, for example
+//
+//	id,password
+//	user_1,pass_1
+//	user_2,pass_2
+//
+// The first row names the columns, client n takes row n modulo the number
+// of users and ${user.column} is replaced by its value in templates. The
+// first column names the identity in the report. A login request, sent by
+// every client before its first request, again every LoginEvery and after
+// a 401 response, stores a value of its response as ${user.variable}, for
+// example a token for -auth "Bearer ${user.token}". Its requests are
+// reported under loginKey.
+const loginKey = "[login]"
+
+type identity struct {
+	name   string
+	values map[string]string
+}
+
+func readUsers(path string) ([]*identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("no users in %s", path)
+	}
+
+	var users []*identity
+	columns := records[0]
+	for _, record := range records[1:] {
+		user := &identity{name: record[0], values: make(map[string]string)}
+		for i, column := range columns {
+			if i < len(record) {
+				user.values[strings.TrimSpace(column)] = record[i]
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Whether ${user.name} has a value, from the user pool or the login
+func (configuration *Configuration) hasUserValue(name string) bool {
+	if len(configuration.users) > 0 {
+		if _, ok := configuration.users[0].values[name]; ok {
+			return true
+		}
+	}
+	return configuration.loginExtract != nil && configuration.loginExtract.variable == name
+}
+
+// Identity of client number n, a copy as the login adds values to it
+func (configuration *Configuration) identityOf(n int) *identity {
+	if len(configuration.users) == 0 {
+		return nil
+	}
+	user := configuration.users[n%len(configuration.users)]
+	values := make(map[string]string, len(user.values)+1)
+	for column, value := range user.values {
+		values[column] = value
+	}
+	return &identity{name: user.name, values: values}
+}
+
+// Log vc in when it has not yet, its login is older than loginEvery or its
+// last response was a 401. A failed login is retried before the next
+// request.
+func (vc *virtualClient) login(configuration *Configuration) {
+	if configuration.login == "" ||
+		(!vc.loginDue && (configuration.loginEvery <= 0 || time.Since(vc.loginAt) < configuration.loginEvery)) {
+		return
+	}
+	vc.loginDue = false
+	vc.loginAt = time.Now()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	line := configuration.login
+	if strings.Contains(line, "[POST]") {
+		results := strings.Split(line, "[POST]")
+		req.SetRequestURI(configuration.expandVars(results[0], vc, nil))
+		req.Header.SetMethod("POST")
+		req.SetBodyString(configuration.expandVars(results[1], vc, nil))
+	} else {
+		req.SetRequestURI(configuration.expandVars(line, vc, nil))
+		req.Header.SetMethod("GET")
+	}
+	setCommonHeaders(configuration, vc, req)
+
+	resp, ok := sendRequest(configuration, vc, loginKey, req, nil, nil, time.Now())
+	// the login does not count against the requests of the client
+	vc.sent--
+	if ok && configuration.loginExtract != nil {
+		value, found := extractValue(configuration.loginExtract, resp)
+		if found && vc.identity != nil {
+			vc.identity.values[configuration.loginExtract.variable] = value
+		}
+		ok = found
+	}
+	if resp != nil {
+		fasthttp.ReleaseResponse(resp)
+	}
+	vc.loginDue = !ok
+}
+
+// Cookies set by the responses to one client, sent back to the hosts they
+// belong to until they expire
+type cookieJar struct {
+	cookies []*jarCookie
+}
+
+type jarCookie struct {
+	name     string
+	value    string
+	domain   string
+	hostOnly bool
+	path     string
+	expires  time.Time
+}
+
+func (c *jarCookie) matches(host string, path string, now time.Time) bool {
+	if !c.expires.IsZero() && now.After(c.expires) {
+		return false
+	}
+	if c.hostOnly && host != c.domain {
+		return false
+	}
+	if !c.hostOnly && host != c.domain && !strings.HasSuffix(host, "."+c.domain) {
+		return false
+	}
+	return strings.HasPrefix(path, c.path)
+}
+
+// Keep the cookies resp sets for uri
+func (jar *cookieJar) update(uri *fasthttp.URI, resp *fasthttp.Response) {
+	host, now := hostOnly(string(uri.Host())), time.Now()
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		cookie := fasthttp.AcquireCookie()
+		defer fasthttp.ReleaseCookie(cookie)
+		if cookie.ParseBytes(value) != nil {
+			return
+		}
+		entry := &jarCookie{name: string(cookie.Key()), value: string(cookie.Value()),
+			domain: strings.TrimPrefix(string(cookie.Domain()), "."), path: string(cookie.Path())}
+		if entry.domain == "" {
+			entry.domain, entry.hostOnly = host, true
+		}
+		if entry.path == "" || !strings.HasPrefix(entry.path, "/") {
+			entry.path = "/"
+		}
+		switch {
+		case cookie.MaxAge() < 0:
+			entry.expires = now
+		case cookie.MaxAge() > 0:
+			entry.expires = now.Add(time.Duration(cookie.MaxAge()) * time.Second)
+		case !cookie.Expire().Equal(fasthttp.CookieExpireUnlimited):
+			entry.expires = cookie.Expire()
+		}
+
+		// a cookie replaces the one of the same name, domain and path
+		for i, old := range jar.cookies {
+			if old.name == entry.name && old.domain == entry.domain && old.path == entry.path {
+				jar.cookies = append(jar.cookies[:i], jar.cookies[i+1:]...)
+				break
+			}
+		}
+		if entry.expires.IsZero() || entry.expires.After(now) {
+			jar.cookies = append(jar.cookies, entry)
+		}
+	})
+}
+
+// Add the cookies of the jar for the URI of req
+func (jar *cookieJar) apply(req *fasthttp.Request) {
+	uri := req.URI()
+	host, path, now := hostOnly(string(uri.Host())), string(uri.Path()), time.Now()
+	for _, cookie := range jar.cookies {
+		if cookie.matches(host, path, now) {
+			req.Header.SetCookie(cookie.name, cookie.value)
+		}
+	}
+}
+
+func hostOnly(host string) string {
+	if idx := strings.LastIndex(host, ":"); idx >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:idx]
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// Requests of one identity, to spot hot keys of the servers
+type identityResult struct {
+	requests int64
+	failed   int64
+}
+
+func mergeIdentities(merged map[string]*identityResult, identities map[string]*identityResult) {
+	for user, counts := range identities {
+		total, ok := merged[user]
+		if !ok {
+			total = &identityResult{}
+			merged[user] = total
+		}
+		total.requests += counts.requests
+		total.failed += counts.failed
+	}
+}
+
+// Requests of every identity, the busiest first
+func identityReports(identities map[string]*identityResult) []Identity {
+	reports := make([]Identity, 0, len(identities))
+	for user, counts := range identities {
+		reports = append(reports, Identity{User: user, Requests: counts.requests, Failed: counts.failed})
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Requests != reports[j].Requests {
+			return reports[i].Requests > reports[j].Requests
+		}
+		return reports[i].User < reports[j].User
+	})
+	return reports
+}
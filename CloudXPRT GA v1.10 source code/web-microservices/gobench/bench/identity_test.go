@@ -0,0 +1,183 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func writeUsersFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "users.csv")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadUsers(t *testing.T) {
+	users, err := readUsers(writeUsersFile(t, "id, password\nuser_1,pass_1\nuser_2,pass_2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].name != "user_2" || users[1].values["password"] != "pass_2" {
+		t.Fatalf("Wrong users %+v", users)
+	}
+
+	configuration := &Configuration{users: users}
+	first := configuration.identityOf(2)
+	first.values["token"] = "abc"
+	if first.name != "user_1" || users[0].values["token"] != "" || configuration.identityOf(3).name != "user_2" {
+		t.Fatalf("Wrong identities of the clients")
+	}
+	if !configuration.hasUserValue("password") || configuration.hasUserValue("token") {
+		t.Fatalf("Wrong user values")
+	}
+
+	if _, err := readUsers(writeUsersFile(t, "id,password\n")); err == nil {
+		t.Fatalf("A pool without users should be invalid")
+	}
+}
+
+func requestCookies(req *fasthttp.Request) string {
+	var cookies []string
+	req.Header.VisitAllCookie(func(key, value []byte) {
+		cookies = append(cookies, string(key)+"="+string(value))
+	})
+	return strings.Join(cookies, "; ")
+}
+
+func responseWithCookies(t *testing.T, cookies ...string) *fasthttp.Response {
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"
+	for _, cookie := range cookies {
+		raw += "Set-Cookie: " + cookie + "\r\n"
+	}
+	resp := &fasthttp.Response{}
+	if err := resp.Read(bufio.NewReader(strings.NewReader(raw + "\r\n"))); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCookieJar(t *testing.T) {
+	jar := &cookieJar{}
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+	uri.Parse(nil, []byte("http://shop.example.com:8080/cart/items"))
+
+	jar.update(uri, responseWithCookies(t, "session=1", "cart=2; Path=/cart", "region=eu; Domain=.example.com",
+		"old=3; Max-Age=-1"))
+
+	expected := []struct {
+		url     string
+		cookies string
+	}{
+		{"http://shop.example.com/cart/1", "session=1; cart=2; region=eu"},
+		{"http://shop.example.com/", "session=1; region=eu"},
+		{"http://api.example.com/cart", "region=eu"},
+		{"http://example.org/", ""},
+	}
+	for _, e := range expected {
+		req := &fasthttp.Request{}
+		req.SetRequestURI(e.url)
+		jar.apply(req)
+		if cookies := requestCookies(req); cookies != e.cookies {
+			t.Fatalf("Cookies of %s are %q, expected %q", e.url, cookies, e.cookies)
+		}
+	}
+
+	// a cookie replaces the one of the same name, expired ones are dropped
+	jar.update(uri, responseWithCookies(t, "session=4", "cart=; Path=/cart; Expires=Thu, 01 Jan 1970 00:00:00 GMT"))
+	req := &fasthttp.Request{}
+	req.SetRequestURI("http://shop.example.com/cart")
+	jar.apply(req)
+	if cookies := requestCookies(req); cookies != "region=eu; session=4" {
+		t.Fatalf("Cookies after the update are %q", cookies)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var logins int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			body, _ := ioutil.ReadAll(r.Body)
+			user := strings.TrimPrefix(string(body), "user=")
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: user})
+			fmt.Fprintf(w, `{"token": "token-%s"}`, user)
+			atomic.AddInt64(&logins, 1)
+			return
+		}
+		session, err := r.Cookie("session")
+		if err != nil || r.Header.Get("Authorization") != "Bearer token-"+session.Value {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{
+		URL:          server.URL + "/mc",
+		Clients:      3,
+		Requests:     4,
+		Users:        writeUsersFile(t, "id\nuser_1\nuser_2\n"),
+		Login:        server.URL + "/login[POST]user=${user.id}",
+		LoginExtract: "token=json:token",
+		Auth:         "Bearer ${user.token}",
+		CookieJar:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the logins are requests of their own URL on top of those of the clients
+	if report.Requests != 15 || report.Success != 15 || atomic.LoadInt64(&logins) != 3 {
+		t.Fatalf("Wrong result with logins: %d requests, %d successful, %d logins", report.Requests, report.Success,
+			atomic.LoadInt64(&logins))
+	}
+	if len(report.Identities) != 2 || report.Identities[0] != (Identity{User: "user_1", Requests: 10}) ||
+		report.Identities[1] != (Identity{User: "user_2", Requests: 5}) {
+		t.Fatalf("Wrong identities %+v", report.Identities)
+	}
+	for _, url := range report.URLs {
+		if url.URL == loginKey && url.Success != 3 {
+			t.Fatalf("Wrong logins %+v", url)
+		}
+	}
+
+	for _, cfg := range []Config{{LoginExtract: "token=json:token"}, {Auth: "${user.id}"}, {FirstClient: -1}} {
+		cfg.URL, cfg.Requests = server.URL, 1
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+}
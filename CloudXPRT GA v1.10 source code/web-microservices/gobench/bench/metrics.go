@@ -0,0 +1,133 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Upper bounds of the response time buckets served on /metrics, in seconds
+var metricsBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Write the live counters of the run in the Prometheus text format, so they
+// can be scraped next to the server side metrics while the run goes on.
+func (r *Runner) WriteMetrics(w io.Writer) {
+	results, startTime := r.results, r.started()
+	stats := mergeURLResults(results)
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	writeMetricHeader(out, "gobench_requests_total", "counter", "Requests sent")
+	for _, key := range keys {
+		fmt.Fprintf(out, "gobench_requests_total{url=\"%s\"} %d\n", labelEscaper.Replace(key), stats[key].requests)
+	}
+	writeMetricHeader(out, "gobench_success_total", "counter", "Requests answered with 200 and the expected content")
+	for _, key := range keys {
+		fmt.Fprintf(out, "gobench_success_total{url=\"%s\"} %d\n", labelEscaper.Replace(key), stats[key].success)
+	}
+	writeMetricHeader(out, "gobench_failures_total", "counter", "Failed requests by reason")
+	for _, key := range keys {
+		url := labelEscaper.Replace(key)
+		fmt.Fprintf(out, "gobench_failures_total{url=\"%s\",reason=\"network\"} %d\n", url, stats[key].networkFailed)
+		fmt.Fprintf(out, "gobench_failures_total{url=\"%s\",reason=\"bad\"} %d\n", url, stats[key].badFailed)
+		fmt.Fprintf(out, "gobench_failures_total{url=\"%s\",reason=\"mismatch\"} %d\n", url, stats[key].mismatched)
+	}
+
+	writeMetricHeader(out, "gobench_url_sent_bytes_total", "counter", "Bytes of the requests sent, headers included")
+	for _, key := range keys {
+		if sent := stats[key].sent; sent != nil {
+			fmt.Fprintf(out, "gobench_url_sent_bytes_total{url=\"%s\"} %d\n", labelEscaper.Replace(key), int64(sent.Sum()))
+		}
+	}
+	writeMetricHeader(out, "gobench_url_received_bytes_total", "counter", "Bytes of the responses received, headers included")
+	for _, key := range keys {
+		if received := stats[key].received; received != nil {
+			fmt.Fprintf(out, "gobench_url_received_bytes_total{url=\"%s\"} %d\n", labelEscaper.Replace(key), int64(received.Sum()))
+		}
+	}
+
+	writeMetricHeader(out, "gobench_response_time_seconds", "histogram", "Response time")
+	for _, key := range keys {
+		url := labelEscaper.Replace(key)
+		hist := stats[key].hist
+		for _, bound := range metricsBuckets {
+			fmt.Fprintf(out, "gobench_response_time_seconds_bucket{url=\"%s\",le=\"%s\"} %d\n", url,
+				strconv.FormatFloat(bound, 'g', -1, 64), hist.CountAtOrBelow(int64(bound*1e6)))
+		}
+		fmt.Fprintf(out, "gobench_response_time_seconds_bucket{url=\"%s\",le=\"+Inf\"} %d\n", url, hist.Count())
+		fmt.Fprintf(out, "gobench_response_time_seconds_sum{url=\"%s\"} %g\n", url, hist.sum/1e6)
+		fmt.Fprintf(out, "gobench_response_time_seconds_count{url=\"%s\"} %d\n", url, hist.Count())
+	}
+
+	if r.balancer != nil {
+		endpoints := make(map[string]*endpointResult)
+		for _, result := range results {
+			result.mu.Lock()
+			mergeEndpoints(endpoints, result.endpoints)
+			result.mu.Unlock()
+		}
+		reports := endpointReports(endpoints, 0)
+		writeMetricHeader(out, "gobench_endpoint_requests_total", "counter", "Requests sent to an endpoint")
+		for _, e := range reports {
+			fmt.Fprintf(out, "gobench_endpoint_requests_total{endpoint=\"%s\"} %d\n", labelEscaper.Replace(e.Address), e.Requests)
+		}
+		writeMetricHeader(out, "gobench_endpoint_failures_total", "counter", "Failed requests sent to an endpoint")
+		for _, e := range reports {
+			fmt.Fprintf(out, "gobench_endpoint_failures_total{endpoint=\"%s\"} %d\n", labelEscaper.Replace(e.Address), e.Failed)
+		}
+		writeMetricHeader(out, "gobench_endpoint_requests_outstanding", "gauge", "Requests waiting for a response of an endpoint")
+		for _, e := range r.balancer.endpoints {
+			fmt.Fprintf(out, "gobench_endpoint_requests_outstanding{endpoint=\"%s\"} %d\n", labelEscaper.Replace(e.addr),
+				atomic.LoadInt64(&e.outstanding))
+		}
+	}
+
+	writeMetricHeader(out, "gobench_requests_in_flight", "gauge", "Requests waiting for a response")
+	fmt.Fprintf(out, "gobench_requests_in_flight %d\n", atomic.LoadInt64(&r.inFlight))
+	writeMetricHeader(out, "gobench_clients", "gauge", "Virtual clients")
+	fmt.Fprintf(out, "gobench_clients %d\n", len(results))
+	writeMetricHeader(out, "gobench_read_bytes_total", "counter", "Bytes read from connections")
+	fmt.Fprintf(out, "gobench_read_bytes_total %d\n", atomic.LoadInt64(&r.readBytes))
+	writeMetricHeader(out, "gobench_written_bytes_total", "counter", "Bytes written to connections")
+	fmt.Fprintf(out, "gobench_written_bytes_total %d\n", atomic.LoadInt64(&r.writeBytes))
+	writeMetricHeader(out, "gobench_connections_opened_total", "counter", "Connections opened")
+	fmt.Fprintf(out, "gobench_connections_opened_total %d\n", atomic.LoadInt64(&r.connsOpened))
+	writeMetricHeader(out, "gobench_connections_closed_total", "counter", "Connections closed by either side")
+	fmt.Fprintf(out, "gobench_connections_closed_total %d\n", atomic.LoadInt64(&r.connsClosed))
+	writeMetricHeader(out, "gobench_connection_errors_total", "counter", "Connections failed to open or to do their TLS handshake")
+	fmt.Fprintf(out, "gobench_connection_errors_total %d\n", atomic.LoadInt64(&r.connErrors))
+	writeMetricHeader(out, "gobench_start_time_seconds", "gauge", "Start time of the run since unix epoch")
+	fmt.Fprintf(out, "gobench_start_time_seconds %d\n", startTime.Unix())
+}
+
+func writeMetricHeader(out io.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
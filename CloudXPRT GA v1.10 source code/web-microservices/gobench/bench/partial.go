@@ -0,0 +1,227 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Results of all clients of one runner of a distributed run, as sent by an
+// agent to its coordinator. Unlike a Report they can be merged.
+type Partial struct {
+	Requests      int64                    `json:"requests"`
+	Success       int64                    `json:"success"`
+	NetworkFailed int64                    `json:"network_failed"`
+	BadFailed     int64                    `json:"bad_failed"`
+	Mismatched    int64                    `json:"mismatched"`
+	Delayed       int64                    `json:"delayed"`
+	MaxDelay      time.Duration            `json:"max_delay"`
+	Started       int64                    `json:"started"`
+	Completed     int64                    `json:"completed"`
+	Aborted       int64                    `json:"aborted"`
+	ReadBytes     int64                    `json:"read_bytes"`
+	WriteBytes    int64                    `json:"write_bytes"`
+	ConnsOpened   int64                    `json:"conns_opened"`
+	ConnsClosed   int64                    `json:"conns_closed"`
+	ConnErrors    int64                    `json:"conn_errors"`
+	MaxStreams    int64                    `json:"max_streams,omitempty"`
+	Excluded      [windowMeasured]Excluded `json:"excluded"`
+	Waits         int64                    `json:"waits"`
+	Waited        time.Duration            `json:"waited"`
+	MaxWait       time.Duration            `json:"max_wait"`
+	URLs          map[string]*PartialURL   `json:"urls"`
+	Identities    []Identity               `json:"identities,omitempty"`
+	Endpoints     []Endpoint               `json:"endpoints,omitempty"`
+	Series        []PartialSeries          `json:"series,omitempty"`
+}
+
+// Time series bucket of a partial result, intervals count from the start
+// of its runner which is about the start of the coordinator
+type PartialSeries struct {
+	Interval      int64      `json:"interval"`
+	URL           string     `json:"url"`
+	Requests      int64      `json:"requests"`
+	Success       int64      `json:"success"`
+	NetworkFailed int64      `json:"network_failed"`
+	BadFailed     int64      `json:"bad_failed"`
+	Mismatched    int64      `json:"mismatched"`
+	Histogram     *Histogram `json:"histogram"`
+}
+
+type PartialURL struct {
+	Requests      int64                  `json:"requests"`
+	Success       int64                  `json:"success"`
+	NetworkFailed int64                  `json:"network_failed"`
+	BadFailed     int64                  `json:"bad_failed"`
+	Mismatched    int64                  `json:"mismatched"`
+	Histogram     *Histogram             `json:"histogram"`
+	NewConns      int64                  `json:"new_connections"`
+	ReusedConns   int64                  `json:"reused_connections"`
+	Phases        [phaseCount]*Histogram `json:"phases"`
+	Failures      []Failure              `json:"failures,omitempty"`
+	TLSResumed    int64                  `json:"tls_resumed"`
+	Negotiated    map[string]int64       `json:"negotiated,omitempty"`
+	Sent          *Histogram             `json:"sent,omitempty"`
+	Received      *Histogram             `json:"received,omitempty"`
+}
+
+// Results of all clients so far
+func (r *Runner) partial() *Partial {
+	partial := &Partial{URLs: make(map[string]*PartialURL)}
+	identities := make(map[string]*identityResult)
+	endpoints := make(map[string]*endpointResult)
+	for _, clientResult := range r.results {
+		clientResult.mu.Lock()
+		mergeIdentities(identities, clientResult.identities)
+		mergeEndpoints(endpoints, clientResult.endpoints)
+		partial.Requests += clientResult.requests
+		partial.Success += clientResult.success
+		partial.NetworkFailed += clientResult.networkFailed
+		partial.BadFailed += clientResult.badFailed
+		partial.Mismatched += clientResult.mismatched
+		partial.Delayed += clientResult.delayed
+		if clientResult.maxDelay > partial.MaxDelay {
+			partial.MaxDelay = clientResult.maxDelay
+		}
+		partial.Waits += clientResult.waits
+		partial.Waited += clientResult.waited
+		if clientResult.maxWait > partial.MaxWait {
+			partial.MaxWait = clientResult.maxWait
+		}
+		partial.Started += clientResult.sessions.started
+		partial.Completed += clientResult.sessions.completed
+		partial.Aborted += clientResult.sessions.aborted
+		for w, excluded := range clientResult.excluded {
+			partial.Excluded[w].Requests += excluded.requests
+			partial.Excluded[w].Success += excluded.success
+			partial.Excluded[w].NetworkFailed += excluded.networkFailed
+			partial.Excluded[w].BadFailed += excluded.badFailed
+			partial.Excluded[w].Mismatched += excluded.mismatched
+		}
+		clientResult.mu.Unlock()
+	}
+	for key, stats := range mergeURLResults(r.results) {
+		partial.URLs[key] = &PartialURL{Requests: stats.requests, Success: stats.success,
+			NetworkFailed: stats.networkFailed, BadFailed: stats.badFailed,
+			Mismatched: stats.mismatched, Histogram: stats.hist,
+			NewConns: stats.newConns, ReusedConns: stats.reusedConns, Phases: stats.phases,
+			Failures: failureReports(stats.failures), TLSResumed: stats.resumed, Negotiated: stats.negotiated,
+			Sent: stats.sent, Received: stats.received}
+	}
+	partial.Identities = identityReports(identities)
+	partial.Endpoints = endpointReports(endpoints, 0)
+	partial.ReadBytes = atomic.LoadInt64(&r.readBytes)
+	partial.WriteBytes = atomic.LoadInt64(&r.writeBytes)
+	partial.ConnsOpened = atomic.LoadInt64(&r.connsOpened)
+	partial.ConnsClosed = atomic.LoadInt64(&r.connsClosed)
+	partial.ConnErrors = atomic.LoadInt64(&r.connErrors)
+	partial.MaxStreams = atomic.LoadInt64(&r.h2MaxStreams)
+	if r.configuration.seriesInterval > 0 {
+		partial.Series = r.partialSeries()
+	}
+	return partial
+}
+
+// Time series buckets of all clients
+func (r *Runner) partialSeries() []PartialSeries {
+	for _, result := range r.results {
+		result.mu.Lock()
+		r.flushSeries(result)
+		result.mu.Unlock()
+	}
+
+	r.series.Lock()
+	defer r.series.Unlock()
+	buckets := make([]PartialSeries, 0, len(r.series.buckets))
+	for key, bucket := range r.series.buckets {
+		buckets = append(buckets, PartialSeries{Interval: key.interval, URL: key.url,
+			Requests: bucket.requests, Success: bucket.success, NetworkFailed: bucket.networkFailed,
+			BadFailed: bucket.badFailed, Mismatched: bucket.mismatched, Histogram: bucket.hist})
+	}
+	return buckets
+}
+
+// Report the partial results of a distributed run started at startTime,
+// in place of running the clients. Missing results are nil.
+func (r *Runner) Merge(startTime time.Time, partials []*Partial) *Report {
+	r.start(startTime)
+	r.results = make(map[int]*Result)
+	for i, partial := range partials {
+		if partial == nil {
+			continue
+		}
+		r.results[i] = partial.toResult()
+		atomic.AddInt64(&r.readBytes, partial.ReadBytes)
+		atomic.AddInt64(&r.writeBytes, partial.WriteBytes)
+		atomic.AddInt64(&r.connsOpened, partial.ConnsOpened)
+		atomic.AddInt64(&r.connsClosed, partial.ConnsClosed)
+		atomic.AddInt64(&r.connErrors, partial.ConnErrors)
+		if partial.MaxStreams > atomic.LoadInt64(&r.h2MaxStreams) {
+			atomic.StoreInt64(&r.h2MaxStreams, partial.MaxStreams)
+		}
+		r.series.Lock()
+		for _, bucket := range partial.Series {
+			if bucket.Histogram == nil {
+				bucket.Histogram = NewHistogram()
+			}
+			r.mergeSeriesBucket(seriesKey{interval: bucket.Interval, url: bucket.URL}, &seriesBucket{
+				requests: bucket.Requests, success: bucket.Success, networkFailed: bucket.NetworkFailed,
+				badFailed: bucket.BadFailed, mismatched: bucket.Mismatched, hist: bucket.Histogram})
+		}
+		r.series.Unlock()
+	}
+	return r.buildReport()
+}
+
+func (p *Partial) toResult() *Result {
+	result := &Result{requests: p.Requests, success: p.Success, networkFailed: p.NetworkFailed,
+		badFailed: p.BadFailed, mismatched: p.Mismatched, delayed: p.Delayed, maxDelay: p.MaxDelay,
+		sessions: sessionResult{started: p.Started, completed: p.Completed, aborted: p.Aborted},
+		waits:    p.Waits, waited: p.Waited, maxWait: p.MaxWait,
+		urls: make(map[string]*urlResult), identities: make(map[string]*identityResult),
+		endpoints: make(map[string]*endpointResult)}
+	for _, counts := range p.Identities {
+		result.identities[counts.User] = &identityResult{requests: counts.Requests, failed: counts.Failed}
+	}
+	for _, e := range p.Endpoints {
+		if e.Histogram == nil {
+			e.Histogram = NewHistogram()
+		}
+		result.endpoints[e.Address] = &endpointResult{requests: e.Requests, failed: e.Failed, hist: e.Histogram}
+	}
+	for w, excluded := range p.Excluded {
+		result.excluded[w] = excludedResult{requests: excluded.Requests, success: excluded.Success,
+			networkFailed: excluded.NetworkFailed, badFailed: excluded.BadFailed,
+			mismatched: excluded.Mismatched}
+	}
+	for key, stats := range p.URLs {
+		if stats.Histogram == nil {
+			stats.Histogram = NewHistogram()
+		}
+		result.urls[key] = &urlResult{requests: stats.Requests, success: stats.Success,
+			networkFailed: stats.NetworkFailed, badFailed: stats.BadFailed,
+			mismatched: stats.Mismatched, hist: stats.Histogram,
+			newConns: stats.NewConns, reusedConns: stats.ReusedConns, phases: stats.Phases,
+			failures: failureResults(stats.Failures), resumed: stats.TLSResumed, negotiated: stats.Negotiated,
+			sent: stats.Sent, received: stats.Received}
+		if stats.Sent != nil && stats.Received == nil {
+			result.urls[key].received = NewHistogram()
+		}
+	}
+	return result
+}
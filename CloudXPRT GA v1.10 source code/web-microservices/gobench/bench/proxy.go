@@ -0,0 +1,172 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// Connections go directly to the server, through a proxy or to a Unix
+// domain socket. Without a proxy of their own, runs take the proxies of the
+// http_proxy, https_proxy and no_proxy environment variables like curl, and
+// proxyDirect turns them off. HTTP and HTTPS proxies open a tunnel with
+// CONNECT for http and https URLs alike, SOCKS5 proxies connect on behalf of
+// the client, and user:password of the proxy URL authenticates to either.
+// Hosts matching no_proxy, and localhost and loopback addresses, are not
+// proxied. With a Unix socket every connection goes to the socket, the
+// Host header stays that of the URL.
+const proxyDirect = "direct"
+
+// Proxy of a configuration, nil to connect directly
+type proxyConfig func(*url.URL) (*url.URL, error)
+
+func newProxyConfig(spec string, noProxy string) (proxyConfig, error) {
+	switch spec {
+	case proxyDirect:
+		return nil, nil
+	case "":
+		return httpproxy.FromEnvironment().ProxyFunc(), nil
+	}
+	proxyURL, err := url.Parse(spec)
+	if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") ||
+		len(proxyURL.Host) == 0 {
+		return nil, fmt.Errorf("proxy %q is not an http, https or socks5 URL", spec)
+	}
+	config := &httpproxy.Config{HTTPProxy: spec, HTTPSProxy: spec, NoProxy: noProxy}
+	return config.ProxyFunc(), nil
+}
+
+// Proxy URL without its password, for the report
+func redactProxy(spec string) string {
+	proxyURL, err := url.Parse(spec)
+	if err != nil || proxyURL.User == nil {
+		return spec
+	}
+	if _, ok := proxyURL.User.Password(); ok {
+		proxyURL.User = url.UserPassword(proxyURL.User.Username(), "xxxxx")
+	}
+	return proxyURL.String()
+}
+
+// Open a connection to addr for an https URL (secure) or an http URL, no
+// timeout when timeout is 0
+func (configuration *Configuration) dial(addr string, secure bool, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if configuration.unixSocket != "" {
+		return dialer.Dial("unix", configuration.unixSocket)
+	}
+	if configuration.proxy == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	proxyURL, err := configuration.proxy(&url.URL{Scheme: scheme, Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	if proxyURL.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", addMissingPort(proxyURL.Host, false), auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.Dial("tcp", addr)
+	}
+	return dialConnect(dialer, proxyURL, addr, timeout)
+}
+
+// Open a tunnel to addr through the HTTP or HTTPS proxy at proxyURL
+func dialConnect(dialer *net.Dialer, proxyURL *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	secure := proxyURL.Scheme == "https"
+	conn, err := dialer.Dial("tcp", addMissingPort(proxyURL.Host, secure))
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if secure {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the body of a successful response is the tunnel
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	// bytes the proxy sent after its response belong to the tunnel
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,230 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Tunnel from conn to addr until either side closes
+func tunnel(conn net.Conn, addr string) {
+	defer conn.Close()
+	server, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	go io.Copy(server, conn)
+	io.Copy(conn, server)
+}
+
+// A CONNECT proxy asking for user:secret that tunnels every target to
+// target, so requests to hosts that do not resolve get through
+func newConnectProxy(t *testing.T, target string, tunnels *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Host != "web-service.invalid:8070" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		atomic.AddInt64(tunnels, 1)
+		go tunnel(conn, target)
+	}))
+}
+
+// A SOCKS5 proxy without authentication that connects every target to
+// target
+func newSOCKS5Proxy(t *testing.T, target string, tunnels *int64) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// greeting with its methods, then a CONNECT request to a
+				// domain name
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, make([]byte, header[1]))
+				conn.Write([]byte{5, 0})
+				request := make([]byte, 5)
+				io.ReadFull(conn, request)
+				name := make([]byte, int(request[4])+2)
+				io.ReadFull(conn, name)
+				if string(name[:request[4]]) != "web-service.invalid" || binary.BigEndian.Uint16(name[request[4]:]) != 8070 {
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				atomic.AddInt64(tunnels, 1)
+				tunnel(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo " + r.Host))
+	}))
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://")
+
+	var connects, socks int64
+	connectProxy := newConnectProxy(t, target, &connects)
+	defer connectProxy.Close()
+	socksProxy := newSOCKS5Proxy(t, target, &socks)
+	defer socksProxy.Close()
+
+	expected := []struct {
+		proxy    string
+		tunnels  *int64
+		success  int64
+		redacted string
+	}{
+		{strings.Replace(connectProxy.URL, "http://", "http://user:secret@", 1), &connects, 8,
+			strings.Replace(connectProxy.URL, "http://", "http://user:xxxxx@", 1)},
+		{"socks5://" + socksProxy.Addr().String(), &socks, 8, "socks5://" + socksProxy.Addr().String()},
+		// a wrong password gets no tunnel
+		{strings.Replace(connectProxy.URL, "http://", "http://user:guess@", 1), &connects, 0,
+			strings.Replace(connectProxy.URL, "http://", "http://user:xxxxx@", 1)},
+	}
+	for _, e := range expected {
+		atomic.StoreInt64(e.tunnels, 0)
+		report, err := Run(context.Background(), Config{URL: "http://web-service.invalid:8070/mc", Clients: 2,
+			Requests: 4, Proxy: e.proxy, Expect: "Monte Carlo web-service.invalid:8070"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tunnels := atomic.LoadInt64(e.tunnels)
+		if report.Success != e.success || (e.success > 0 && tunnels != 2) || report.Config.Proxy != e.redacted {
+			t.Fatalf("Wrong result through %s: %d successful, %d tunnels, proxy %s", e.proxy, report.Success,
+				tunnels, report.Config.Proxy)
+		}
+	}
+
+	for _, cfg := range []Config{{Proxy: "ftp://proxy:21"}, {Proxy: "proxy:3128"}, {NoProxy: "example.com"},
+		{UnixSocket: "/tmp/gobench.sock", Proxy: connectProxy.URL}, {UnixSocket: "/tmp/gobench.sock", Endpoints: target}} {
+		cfg.URL, cfg.Requests = server.URL, 1
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cnbserver.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Monte Carlo " + r.Host))
+		})}}
+	server.Start()
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{URL: "http://cnbserver/mc", Clients: 2, Requests: 3,
+		UnixSocket: path, Expect: "Monte Carlo cnbserver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Success != 6 || report.Config.UnixSocket != path {
+		t.Fatalf("Wrong result over %s: %d successful", path, report.Success)
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skip("No IPv6 loopback: ", err)
+		}
+		listeners[i] = listener
+	}
+	var urls []string
+	for _, listener := range listeners {
+		server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Monte Carlo"))
+			})}}
+		server.Start()
+		defer server.Close()
+		urls = append(urls, server.URL+"/mc")
+	}
+
+	dir, err := ioutil.TempDir("", "urls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// every URL keeps its own rules
+	path := filepath.Join(dir, "urls.txt")
+	content := "WEIGHT:1\n" + urls[0] + "[SLA]p95<=1000\n" + urls[1] + "[SLA]errors<=0%[THINK]1\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(context.Background(), Config{URLFile: path, Clients: 2, Requests: 4, Expect: "Monte Carlo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Success != 8 || report.SLA == nil || !report.SLA.Passed || len(report.SLA.Results) != 2 {
+		t.Fatalf("Wrong result over IPv6: %d successful, verdict %+v", report.Success, report.SLA)
+	}
+	for _, result := range report.SLA.Results {
+		if (result.URL == urls[0]) != (result.Rule == "p95<=1000") {
+			t.Fatalf("Wrong rule of %s: %s", result.URL, result.Rule)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// A replay file is recorded traffic, either an access log in the common or
+// combined format of nginx and Apache, or a HAR file saved by a browser. The
+// requests are sent again at their recorded times since the first one,
+// divided by the speed, and are reported by method and URL without query.
+type replayEntry struct {
+	offset  time.Duration
+	method  string
+	url     string
+	key     string
+	body    []byte
+	headers [][2]string
+}
+
+type replaySlot struct {
+	entry    *replayEntry
+	intended time.Time
+}
+
+// remote - user [time] "METHOD target PROTOCOL" status size ...
+var accessLogPattern = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" \d{3} `)
+
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// Headers of recorded requests that are set by gobench or would break it
+var skippedHeaders = map[string]bool{"host": true, "content-length": true, "connection": true,
+	"accept-encoding": true, "keep-alive": true, "transfer-encoding": true}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// Read the requests of a replay file. target (scheme://host:port) replaces
+// the host of recorded URLs and is required for access logs, which only
+// have paths. Skipped lines of access logs are counted on log.
+func readReplay(log io.Writer, path string, target string) ([]*replayEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*replayEntry
+	var times []time.Time
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		entries, times, err = parseHAR(data, target)
+	} else {
+		entries, times, err = parseAccessLog(log, data, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no request found")
+	}
+
+	// logs are written when requests finish, replay them as they started
+	index := make([]int, len(entries))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool { return times[index[i]].Before(times[index[j]]) })
+	sorted := make([]*replayEntry, len(entries))
+	for i, k := range index {
+		sorted[i] = entries[k]
+		sorted[i].offset = times[k].Sub(times[index[0]])
+	}
+	return sorted, nil
+}
+
+func parseAccessLog(log io.Writer, data []byte, target string) ([]*replayEntry, []time.Time, error) {
+	if target == "" {
+		return nil, nil, fmt.Errorf("access logs need a target")
+	}
+
+	var entries []*replayEntry
+	var times []time.Time
+	skipped := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		match := accessLogPattern.FindStringSubmatch(line)
+		if match == nil {
+			skipped++
+			continue
+		}
+		t, err := time.Parse(accessLogTime, match[1])
+		if err != nil || !strings.HasPrefix(match[3], "/") {
+			skipped++
+			continue
+		}
+		entries = append(entries, newReplayEntry(match[2], strings.TrimRight(target, "/")+match[3]))
+		times = append(times, t)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(log, "Skipped %d lines of the access log\n", skipped)
+	}
+	return entries, times, scanner.Err()
+}
+
+func parseHAR(data []byte, target string) ([]*replayEntry, []time.Time, error) {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, nil, err
+	}
+
+	var entries []*replayEntry
+	var times []time.Time
+	for _, harEntry := range har.Log.Entries {
+		request := harEntry.Request
+		recorded, err := neturl.Parse(request.URL)
+		if err != nil || recorded.Host == "" {
+			continue
+		}
+		if target != "" {
+			recorded.Scheme, recorded.Host = "", ""
+			request.URL = strings.TrimRight(target, "/") + recorded.String()
+		}
+
+		entry := newReplayEntry(request.Method, request.URL)
+		for _, header := range request.Headers {
+			if strings.HasPrefix(header.Name, ":") || skippedHeaders[strings.ToLower(header.Name)] {
+				continue
+			}
+			entry.headers = append(entry.headers, [2]string{header.Name, header.Value})
+		}
+		if request.PostData != nil {
+			entry.body = []byte(request.PostData.Text)
+		}
+		entries = append(entries, entry)
+		times = append(times, harEntry.StartedDateTime)
+	}
+	return entries, times, nil
+}
+
+func newReplayEntry(method string, rawURL string) *replayEntry {
+	method = strings.ToUpper(method)
+	endpoint := rawURL
+	if i := strings.IndexAny(endpoint, "?#"); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	return &replayEntry{method: method, url: rawURL, key: method + " " + endpoint}
+}
+
+// Emit every recorded request at its time, scaled by the replay speed
+func replaySchedule(ctx context.Context, configuration *Configuration, slots chan<- replaySlot) {
+	defer close(slots)
+	start := time.Now()
+	for _, entry := range configuration.replay {
+		next := start.Add(time.Duration(float64(entry.offset) / configuration.speed))
+		if !sleepUntil(ctx, next) {
+			return
+		}
+		select {
+		case slots <- replaySlot{entry: entry, intended: next}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Replay client: send the recorded requests given by the scheduler. Like in
+// open-loop mode, response time is measured from the recorded time.
+func replayWorker(configuration *Configuration, vc *virtualClient, slots <-chan replaySlot, done *sync.WaitGroup) {
+	defer done.Done()
+
+	pattern := []byte(configuration.expect)
+	for slot := range slots {
+		if vc.ctx.Err() != nil {
+			return
+		}
+		entry := slot.entry
+		vc.run.updateDelay(vc.result, slot.intended)
+
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(entry.url)
+		req.Header.SetMethod(entry.method)
+		if len(entry.body) > 0 {
+			req.SetBody(entry.body)
+		}
+		for _, header := range entry.headers {
+			req.Header.Set(header[0], header[1])
+		}
+		setCommonHeaders(configuration, vc, req)
+
+		resp, _ := sendRequest(configuration, vc, entry.key, req, pattern, configuration.check, slot.intended)
+		if resp != nil {
+			fasthttp.ReleaseResponse(resp)
+		}
+		fasthttp.ReleaseRequest(req)
+	}
+}
@@ -0,0 +1,89 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeReplayFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "replay")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadAccessLog(t *testing.T) {
+	path := writeReplayFile(t, `10.0.0.1 - - [18/Oct/2020:10:00:02 +0000] "GET /mc?name=a HTTP/1.1" 200 12 "-" "curl"
+not a request
+10.0.0.2 - bob [18/Oct/2020:10:00:00 +0000] "POST /users HTTP/1.1" 201 0
+`)
+	entries, err := readReplay(ioutil.Discard, path, "http://svc:8070/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 requests, got %d.", len(entries))
+	}
+	if entries[0].key != "POST http://svc:8070/users" || entries[0].offset != 0 {
+		t.Fatalf("Requests are not in the order they started: %+v", entries[0])
+	}
+	if entries[1].url != "http://svc:8070/mc?name=a" || entries[1].key != "GET http://svc:8070/mc" ||
+		entries[1].offset != 2*time.Second {
+		t.Fatalf("Wrong replay entry %+v", entries[1])
+	}
+
+	if _, err := readReplay(ioutil.Discard, path, ""); err == nil {
+		t.Fatal("Access logs should need a target.")
+	}
+}
+
+func TestReadHAR(t *testing.T) {
+	path := writeReplayFile(t, `{"log": {"entries": [
+{"startedDateTime": "2020-10-18T10:00:00.000Z", "request": {"method": "get", "url": "https://ui.example/mc?x=1",
+ "headers": [{"name": "Host", "value": "ui.example"}, {"name": ":path", "value": "/mc"}, {"name": "X-Id", "value": "7"}]}},
+{"startedDateTime": "2020-10-18T10:00:00.250Z", "request": {"method": "POST", "url": "https://ui.example/users",
+ "headers": [], "postData": {"mimeType": "application/json", "text": "{}"}}}]}}`)
+	entries, err := readReplay(ioutil.Discard, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].method != "GET" || entries[0].url != "https://ui.example/mc?x=1" {
+		t.Fatalf("Wrong replay entries %+v", entries)
+	}
+	if len(entries[0].headers) != 1 || entries[0].headers[0][0] != "X-Id" {
+		t.Fatalf("Only X-Id should be replayed, got %v", entries[0].headers)
+	}
+	if string(entries[1].body) != "{}" || entries[1].offset != 250*time.Millisecond {
+		t.Fatalf("Wrong replay entry %+v", entries[1])
+	}
+
+	entries, err = readReplay(ioutil.Discard, path, "http://127.0.0.1:8070")
+	if err != nil || entries[0].url != "http://127.0.0.1:8070/mc?x=1" {
+		t.Fatalf("Target should replace the recorded host: %v", err)
+	}
+}
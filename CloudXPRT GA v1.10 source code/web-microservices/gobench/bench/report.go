@@ -0,0 +1,832 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Version of the JSON/CSV report layout, bump it on incompatible changes
+const reportVersion = 1
+
+type Report struct {
+	Version         int          `json:"version"`
+	StartTime       time.Time    `json:"start_time"`
+	Config          ReportConfig `json:"config"`
+	Requests        int64        `json:"requests"`
+	Success         int64        `json:"success"`
+	NetworkFailed   int64        `json:"network_failed"`
+	BadFailed       int64        `json:"bad_failed"`
+	Mismatched      int64        `json:"mismatched"`
+	SuccessRate     float64      `json:"success_rate"`
+	ReadThroughput  int64        `json:"read_throughput"`
+	WriteThroughput int64        `json:"write_throughput"`
+	TestTime        int64        `json:"test_time"`
+	NewConns        int64        `json:"new_connections"`
+	ReusedConns     int64        `json:"reused_connections"`
+	Connections     Connections  `json:"connections"`
+	OpenLoop        *OpenLoop    `json:"open_loop,omitempty"`
+	Sessions        *Sessions    `json:"sessions,omitempty"`
+	Think           *Think       `json:"think,omitempty"`
+	TLS             *TLSReport   `json:"tls,omitempty"`
+	HTTP2           *HTTP2Report `json:"http2,omitempty"`
+	Warmup          *Excluded    `json:"warmup,omitempty"`
+	Cooldown        *Excluded    `json:"cooldown,omitempty"`
+	URLs            []URLReport  `json:"urls"`
+	Identities      []Identity   `json:"identities,omitempty"`
+	Endpoints       []Endpoint   `json:"endpoints,omitempty"`
+	SLA             *SLAReport   `json:"sla,omitempty"`
+	// time series of the run when it has an interval, written on its own
+	Series []SeriesPoint `json:"-"`
+}
+
+// Effective configuration of the run
+type ReportConfig struct {
+	URLs         []string  `json:"urls"`
+	Clients      int       `json:"clients"`
+	Requests     int64     `json:"requests"`
+	Period       int64     `json:"period"`
+	Method       string    `json:"method"`
+	KeepAlive    bool      `json:"keep_alive"`
+	ReadTimeout  int       `json:"read_timeout"`
+	WriteTimeout int       `json:"write_timeout"`
+	Expect       string    `json:"expect,omitempty"`
+	Checks       []string  `json:"checks,omitempty"`
+	ArrivalRate  float64   `json:"arrival_rate,omitempty"`
+	ArrivalMode  string    `json:"arrival_mode,omitempty"`
+	Steps        []string  `json:"steps,omitempty"`
+	Phases       bool      `json:"phases"`
+	Percentiles  []float64 `json:"percentiles"`
+	Ramp         int       `json:"ramp,omitempty"`
+	Warmup       int       `json:"warmup,omitempty"`
+	Cooldown     int       `json:"cooldown,omitempty"`
+	Protocol     string    `json:"protocol"`
+	ConnMode     string    `json:"conn_mode"`
+	PoolSize     int       `json:"pool_size,omitempty"`
+	ConnChurn    float64   `json:"conn_churn,omitempty"`
+	Endpoints    []string  `json:"endpoints,omitempty"`
+	Balance      string    `json:"balance,omitempty"`
+	Proxy        string    `json:"proxy,omitempty"`
+	UnixSocket   string    `json:"unix_socket,omitempty"`
+	Replay       string    `json:"replay,omitempty"`
+	Speed        float64   `json:"speed,omitempty"`
+}
+
+type OpenLoop struct {
+	TargetRate  float64 `json:"target_rate"`
+	OfferedRate float64 `json:"offered_rate"`
+	Delayed     int64   `json:"delayed"`
+	MaxDelay    int64   `json:"max_delay"`
+}
+
+// Requests of one identity of the user pool, failed ones included
+type Identity struct {
+	User     string `json:"user"`
+	Requests int64  `json:"requests"`
+	Failed   int64  `json:"failed"`
+}
+
+// Requests sent to one endpoint of client-side load balancing, failed ones
+// included, their rate per second of test time and response times in
+// microseconds
+type Endpoint struct {
+	Address   string     `json:"address"`
+	Requests  int64      `json:"requests"`
+	Failed    int64      `json:"failed"`
+	Rate      float64    `json:"rate"`
+	Mean      float64    `json:"mean"`
+	P50       int64      `json:"p50"`
+	P95       int64      `json:"p95"`
+	P99       int64      `json:"p99"`
+	Max       int64      `json:"max"`
+	Histogram *Histogram `json:"histogram"`
+}
+
+// Scenario sessions, aborted ones stopped at a failed step, incomplete ones
+// still running at the end of the run
+type Sessions struct {
+	Completed  int64 `json:"completed"`
+	Aborted    int64 `json:"aborted"`
+	Incomplete int64 `json:"incomplete"`
+}
+
+// Waits of the clients for think time and pacing, in milliseconds, and the
+// resulting request rate of a client
+type Think struct {
+	Think      string  `json:"think,omitempty"`
+	Pace       int     `json:"pace,omitempty"`
+	Waits      int64   `json:"waits"`
+	Mean       float64 `json:"mean"`
+	Max        int64   `json:"max"`
+	ClientRate float64 `json:"client_rate"`
+}
+
+// TLS handshakes of the new connections, in microseconds, by negotiated
+// version and cipher suite. Failed counts the requests that failed with a
+// TLS error, handshakes that timed out are network timeouts.
+type TLSReport struct {
+	Handshakes int64            `json:"handshakes"`
+	Resumed    int64            `json:"resumed"`
+	Failed     int64            `json:"failed"`
+	Mean       float64          `json:"mean"`
+	P50        int64            `json:"p50"`
+	P95        int64            `json:"p95"`
+	P99        int64            `json:"p99"`
+	Max        int64            `json:"max"`
+	Negotiated map[string]int64 `json:"negotiated"`
+}
+
+// Connections opened, closed by either side and failed to open or to do
+// their TLS handshake in the measured window
+type Connections struct {
+	Opened int64 `json:"opened"`
+	Closed int64 `json:"closed"`
+	Errors int64 `json:"errors"`
+}
+
+// Connections opened and streams sent by h2 and h2c, MaxStreams is the most
+// concurrent streams seen on one connection
+type HTTP2Report struct {
+	Connections int64 `json:"connections"`
+	Streams     int64 `json:"streams"`
+	MaxStreams  int64 `json:"max_streams"`
+}
+
+// Requests of the warm-up or cool-down window, which are not counted
+// anywhere else in the report. Seconds is the length of the window.
+type Excluded struct {
+	Seconds       int   `json:"seconds"`
+	Requests      int64 `json:"requests"`
+	Success       int64 `json:"success"`
+	NetworkFailed int64 `json:"network_failed"`
+	BadFailed     int64 `json:"bad_failed"`
+	Mismatched    int64 `json:"mismatched"`
+}
+
+// Statistics of a URL or scenario step, response times are in microseconds
+type URLReport struct {
+	URL           string       `json:"url"`
+	Requests      int64        `json:"requests"`
+	Success       int64        `json:"success"`
+	NetworkFailed int64        `json:"network_failed"`
+	BadFailed     int64        `json:"bad_failed"`
+	Mismatched    int64        `json:"mismatched"`
+	Responses     int64        `json:"responses"`
+	Min           int64        `json:"min"`
+	Mean          float64      `json:"mean"`
+	Max           int64        `json:"max"`
+	StdDev        float64      `json:"stddev"`
+	Percentiles   []Percentile `json:"percentiles"`
+	Apdex         *Apdex       `json:"apdex,omitempty"`
+	Histogram     *Histogram   `json:"histogram"`
+	NewConns      int64        `json:"new_connections"`
+	ReusedConns   int64        `json:"reused_connections"`
+	Phases        []Phase      `json:"phases,omitempty"`
+	TLSResumed    int64        `json:"tls_resumed,omitempty"`
+	Failures      []Failure    `json:"failures,omitempty"`
+	Sent          *Payload     `json:"sent,omitempty"`
+	Received      *Payload     `json:"received,omitempty"`
+}
+
+// Failures of one kind: a status code other than 200 (http), a class of
+// network error (network), a response without the expected pattern
+// (mismatch) or a response failing a validation rule (check, with the rule
+// as Reason). Sample is the first message of a network error.
+type Failure struct {
+	Kind      string    `json:"kind"`
+	Status    int       `json:"status,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Sample    string    `json:"sample,omitempty"`
+}
+
+// Time spent in one phase of the requests, in microseconds. Dial and tls
+// only count requests that opened a new connection.
+type Phase struct {
+	Phase     string     `json:"phase"`
+	Count     int64      `json:"count"`
+	Mean      float64    `json:"mean"`
+	P50       int64      `json:"p50"`
+	P95       int64      `json:"p95"`
+	P99       int64      `json:"p99"`
+	Max       int64      `json:"max"`
+	Histogram *Histogram `json:"histogram"`
+}
+
+// Sizes of the requests sent or the responses received of a URL in bytes,
+// headers included, and their total per second of test time
+type Payload struct {
+	Count       int64        `json:"count"`
+	Total       int64        `json:"total"`
+	Rate        int64        `json:"rate"`
+	Mean        float64      `json:"mean"`
+	Min         int64        `json:"min"`
+	Max         int64        `json:"max"`
+	Percentiles []Percentile `json:"percentiles"`
+	Histogram   *Histogram   `json:"-"`
+}
+
+func newPayload(hist *Histogram, pcts []float64, elapsed int64) *Payload {
+	payload := &Payload{Count: hist.Count(), Total: int64(hist.Sum()), Mean: hist.Mean(),
+		Min: hist.Min(), Max: hist.Max(), Histogram: hist}
+	payload.Rate = payload.Total / elapsed
+	for _, pct := range pcts {
+		payload.Percentiles = append(payload.Percentiles, Percentile{Percentile: pct, Value: hist.ValueAtPercentile(pct)})
+	}
+	return payload
+}
+
+type Percentile struct {
+	Percentile float64 `json:"percentile"`
+	Value      int64   `json:"value"`
+}
+
+// Thresholds are in milliseconds
+type Apdex struct {
+	SatisfiedThreshold int     `json:"satisfied_threshold"`
+	ToleratedThreshold int     `json:"tolerated_threshold"`
+	Satisfied          int64   `json:"satisfied"`
+	Tolerated          int64   `json:"tolerated"`
+	Frustrated         int64   `json:"frustrated"`
+	Score              float64 `json:"score"`
+}
+
+func (r *Runner) buildReport() *Report {
+	configuration, results, startTime := r.configuration, r.results, r.started()
+	report := &Report{Version: reportVersion, StartTime: startTime, URLs: make([]URLReport, 0)}
+	var delayed int64
+	var maxDelay time.Duration
+	var sessions Sessions
+	var excluded [windowMeasured]Excluded
+	var waits int64
+	var waited, maxWait time.Duration
+	identities := make(map[string]*identityResult)
+	endpoints := make(map[string]*endpointResult)
+
+	for _, result := range results {
+		result.mu.Lock()
+		mergeIdentities(identities, result.identities)
+		mergeEndpoints(endpoints, result.endpoints)
+		report.Requests += result.requests
+		report.Success += result.success
+		report.NetworkFailed += result.networkFailed
+		report.BadFailed += result.badFailed
+		report.Mismatched += result.mismatched
+		sessions.Completed += result.sessions.completed
+		sessions.Aborted += result.sessions.aborted
+		sessions.Incomplete += result.sessions.started - result.sessions.completed - result.sessions.aborted
+		delayed += result.delayed
+		if result.maxDelay > maxDelay {
+			maxDelay = result.maxDelay
+		}
+		waits += result.waits
+		waited += result.waited
+		if result.maxWait > maxWait {
+			maxWait = result.maxWait
+		}
+		for w := range excluded {
+			excluded[w].Requests += result.excluded[w].requests
+			excluded[w].Success += result.excluded[w].success
+			excluded[w].NetworkFailed += result.excluded[w].networkFailed
+			excluded[w].BadFailed += result.excluded[w].badFailed
+			excluded[w].Mismatched += result.excluded[w].mismatched
+		}
+		result.mu.Unlock()
+	}
+
+	elapsed := int64(r.window.elapsed(startTime).Seconds())
+
+	if elapsed == 0 {
+		elapsed = 1
+	}
+
+	report.TestTime = elapsed
+	report.SuccessRate = float64(report.Success) / float64(elapsed)
+	report.ReadThroughput = atomic.LoadInt64(&r.readBytes) / elapsed
+	report.WriteThroughput = atomic.LoadInt64(&r.writeBytes) / elapsed
+	report.Connections = Connections{Opened: atomic.LoadInt64(&r.connsOpened),
+		Closed: atomic.LoadInt64(&r.connsClosed), Errors: atomic.LoadInt64(&r.connErrors)}
+	report.Config = buildReportConfig(configuration)
+
+	if configuration.arrivalRate > 0 {
+		report.OpenLoop = &OpenLoop{
+			TargetRate:  configuration.arrivalRate,
+			OfferedRate: float64(report.Requests) / float64(elapsed),
+			Delayed:     delayed,
+			MaxDelay:    int64(maxDelay / time.Millisecond)}
+	}
+
+	if configuration.scenario != nil {
+		report.Sessions = &sessions
+	}
+
+	if configuration.think != nil || configuration.pace > 0 || waits > 0 {
+		report.Think = &Think{Think: configuration.thinkSpec, Pace: int(configuration.pace / time.Millisecond), Waits: waits, Max: int64(maxWait / time.Millisecond),
+			ClientRate: float64(report.Requests) / float64(configuration.clients) / float64(elapsed)}
+		if waits > 0 {
+			report.Think.Mean = float64(waited) / float64(waits) / float64(time.Millisecond)
+		}
+	}
+
+	if configuration.warmup > 0 {
+		report.Warmup = &excluded[windowWarmup]
+		report.Warmup.Seconds = int(configuration.warmup / time.Second)
+	}
+	if configuration.cooldown > 0 {
+		report.Cooldown = &excluded[windowCooldown]
+		report.Cooldown.Seconds = int(configuration.cooldown / time.Second)
+	}
+
+	if len(identities) > 0 {
+		report.Identities = identityReports(identities)
+	}
+	if len(endpoints) > 0 {
+		report.Endpoints = endpointReports(endpoints, report.TestTime)
+	}
+
+	stats := mergeURLResults(results)
+	handshakes := NewHistogram()
+	tlsReport := &TLSReport{Negotiated: make(map[string]int64)}
+	keys := make([]string, len(stats))
+	var i = 0
+	for key := range stats {
+		keys[i] = key
+		i++
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := stats[key].hist
+		urlReport := URLReport{URL: key,
+			Requests:      stats[key].requests,
+			Success:       stats[key].success,
+			NetworkFailed: stats[key].networkFailed,
+			BadFailed:     stats[key].badFailed,
+			Mismatched:    stats[key].mismatched,
+			Responses:     hist.Count(), Min: hist.Min(), Mean: hist.Mean(),
+			Max: hist.Max(), StdDev: hist.StdDev(), Histogram: hist,
+			NewConns:    stats[key].newConns,
+			ReusedConns: stats[key].reusedConns,
+			TLSResumed:  stats[key].resumed,
+			Failures:    failureReports(stats[key].failures)}
+		report.NewConns += stats[key].newConns
+		report.ReusedConns += stats[key].reusedConns
+		if tlsHist := stats[key].phases[phaseTLS]; tlsHist != nil {
+			handshakes.Merge(tlsHist)
+		}
+		tlsReport.Resumed += stats[key].resumed
+		for negotiated, count := range stats[key].negotiated {
+			tlsReport.Negotiated[negotiated] += count
+		}
+		for _, failure := range urlReport.Failures {
+			if failure.Kind == failureNetwork && failure.Reason == "tls" {
+				tlsReport.Failed += failure.Count
+			}
+		}
+		for phase, phaseHist := range stats[key].phases {
+			if phaseHist != nil {
+				urlReport.Phases = append(urlReport.Phases, Phase{Phase: phaseNames[phase],
+					Count: phaseHist.Count(), Mean: phaseHist.Mean(),
+					P50: phaseHist.ValueAtPercentile(50), P95: phaseHist.ValueAtPercentile(95),
+					P99: phaseHist.ValueAtPercentile(99), Max: phaseHist.Max(), Histogram: phaseHist})
+			}
+		}
+		for _, pct := range report.Config.Percentiles {
+			urlReport.Percentiles = append(urlReport.Percentiles,
+				Percentile{Percentile: pct, Value: hist.ValueAtPercentile(pct)})
+		}
+		if stats[key].sent != nil {
+			urlReport.Sent = newPayload(stats[key].sent, report.Config.Percentiles, elapsed)
+			urlReport.Received = newPayload(stats[key].received, report.Config.Percentiles, elapsed)
+		}
+
+		// Mainly for file contains multi URLs case
+		timeThreshold := configuration.timeThreshold
+		if val, ok := configuration.thresholds[key]; ok {
+			timeThreshold = val
+		}
+
+		// If time threshold is set as lower:upper format, calculate the Apdex score here,
+		// thresholds were checked with the configuration
+		if strings.Contains(timeThreshold, ":") {
+			tlower, tupper, _ := handleTimeThreshold(timeThreshold)
+			// thresholds are whole milliseconds, so anything below the next
+			// millisecond still meets them
+			satisfied := hist.CountAtOrBelow(int64(tlower)*1000 + 999)
+			tolerated := hist.CountAtOrBelow(int64(tupper)*1000+999) - satisfied
+			urlReport.Apdex = &Apdex{
+				SatisfiedThreshold: tlower,
+				ToleratedThreshold: tupper,
+				Satisfied:          satisfied,
+				Tolerated:          tolerated,
+				Frustrated:         hist.Count() - satisfied - tolerated,
+				Score:              (float64(satisfied) + float64(tolerated)/2.0) / float64(hist.Count())}
+		}
+		report.URLs = append(report.URLs, urlReport)
+	}
+
+	if configuration.protocol != protoHTTP1 {
+		report.HTTP2 = &HTTP2Report{Connections: report.NewConns,
+			Streams: report.NewConns + report.ReusedConns, MaxStreams: atomic.LoadInt64(&r.h2MaxStreams)}
+	}
+
+	if handshakes.Count() > 0 || tlsReport.Failed > 0 {
+		tlsReport.Handshakes = handshakes.Count()
+		tlsReport.Mean, tlsReport.Max = handshakes.Mean(), handshakes.Max()
+		tlsReport.P50, tlsReport.P95 = handshakes.ValueAtPercentile(50), handshakes.ValueAtPercentile(95)
+		tlsReport.P99 = handshakes.ValueAtPercentile(99)
+		report.TLS = tlsReport
+	}
+
+	report.SLA = configuration.evaluateSLA(report)
+
+	if configuration.seriesInterval > 0 {
+		report.Series = r.seriesPoints(report.Config.Percentiles)
+	}
+	return report
+}
+
+func buildReportConfig(configuration *Configuration) ReportConfig {
+	config := ReportConfig{
+		URLs:         make([]string, 0),
+		Clients:      configuration.clients,
+		Requests:     -1,
+		Period:       int64(configuration.period / time.Second),
+		Method:       configuration.method,
+		KeepAlive:    configuration.keepAlive,
+		ReadTimeout:  int(configuration.readTimeout / time.Millisecond),
+		WriteTimeout: int(configuration.writeTimeout / time.Millisecond),
+		Expect:       configuration.expect,
+		Phases:       configuration.phases,
+		Protocol:     configuration.protocol,
+		ConnMode:     configuration.connMode,
+		PoolSize:     configuration.poolSize,
+		ConnChurn:    configuration.connChurn,
+		Endpoints:    configuration.endpoints,
+		Percentiles:  configuration.percentiles,
+		Ramp:         int(configuration.ramp / time.Second),
+		Warmup:       int(configuration.warmup / time.Second),
+		Cooldown:     int(configuration.cooldown / time.Second)}
+
+	if configuration.requests != unlimited {
+		config.Requests = configuration.requests
+	}
+
+	if configuration.arrivalRate > 0 {
+		config.ArrivalRate = configuration.arrivalRate
+		config.ArrivalMode = configuration.arrivalMode
+	}
+
+	for _, c := range configuration.check {
+		config.Checks = append(config.Checks, c.text)
+	}
+
+	if len(configuration.endpoints) > 0 {
+		config.Balance = configuration.balance
+	}
+	if configuration.proxySpec != "" {
+		config.Proxy = redactProxy(configuration.proxySpec)
+	}
+	config.UnixSocket = configuration.unixSocket
+
+	if configuration.replay != nil {
+		config.Replay = configuration.replayFile
+		config.Speed = configuration.speed
+	}
+
+	// URLs are repeated by weight, only list each of them once
+	seen := make(map[string]struct{})
+	for _, tmpURL := range configuration.urls {
+		if _, ok := seen[tmpURL]; !ok {
+			seen[tmpURL] = struct{}{}
+			config.URLs = append(config.URLs, tmpURL)
+		}
+	}
+	sort.Strings(config.URLs)
+
+	for _, step := range configuration.scenario {
+		config.Steps = append(config.Steps, step.name)
+	}
+	return config
+}
+
+// Merge the statistics of all clients by URL
+func mergeURLResults(results map[int]*Result) map[string]*urlResult {
+	stats := make(map[string]*urlResult)
+	for _, result := range results {
+		result.mu.Lock()
+		for key, clientStats := range result.urls {
+			merged, ok := stats[key]
+			if !ok {
+				merged = &urlResult{hist: NewHistogram()}
+				stats[key] = merged
+			}
+			merged.requests += clientStats.requests
+			merged.success += clientStats.success
+			merged.networkFailed += clientStats.networkFailed
+			merged.badFailed += clientStats.badFailed
+			merged.mismatched += clientStats.mismatched
+			merged.hist.Merge(clientStats.hist)
+			merged.mergeFailures(clientStats.failures)
+			merged.newConns += clientStats.newConns
+			merged.reusedConns += clientStats.reusedConns
+			merged.resumed += clientStats.resumed
+			for negotiated, count := range clientStats.negotiated {
+				if merged.negotiated == nil {
+					merged.negotiated = make(map[string]int64)
+				}
+				merged.negotiated[negotiated] += count
+			}
+			for phase, phaseHist := range clientStats.phases {
+				if phaseHist != nil {
+					if merged.phases[phase] == nil {
+						merged.phases[phase] = NewHistogram()
+					}
+					merged.phases[phase].Merge(phaseHist)
+				}
+			}
+			if clientStats.sent != nil {
+				if merged.sent == nil {
+					merged.sent, merged.received = NewHistogram(), NewHistogram()
+				}
+				merged.sent.Merge(clientStats.sent)
+				merged.received.Merge(clientStats.received)
+			}
+		}
+		result.mu.Unlock()
+	}
+	return stats
+}
+
+// Write a report in the text, json or csv format
+func WriteReport(w io.Writer, report *Report, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "csv":
+		return writeCSVReport(w, report)
+	default:
+		writeTextReport(w, report)
+		return nil
+	}
+}
+
+func writeTextReport(w io.Writer, report *Report) {
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Requests:                       %10d hits\n", report.Requests)
+	fmt.Fprintf(w, "Successful requests:            %10d hits\n", report.Success)
+	fmt.Fprintf(w, "Network failed:                 %10d hits\n", report.NetworkFailed)
+	fmt.Fprintf(w, "Bad requests failed (!2xx):     %10d hits\n", report.BadFailed)
+	fmt.Fprintf(w, "Pattern mismatch:               %10d hits\n", report.Mismatched)
+	fmt.Fprintf(w, "Successful requests rate:       %10.2f hits/sec\n", report.SuccessRate)
+	fmt.Fprintf(w, "Read throughput:                %10d bytes/sec\n", report.ReadThroughput)
+	fmt.Fprintf(w, "Write throughput:               %10d bytes/sec\n", report.WriteThroughput)
+	fmt.Fprintf(w, "Test time:                      %10d sec\n", report.TestTime)
+	if report.Warmup != nil {
+		fmt.Fprintf(w, "Warm-up (excluded):             %10d hits in %d sec\n", report.Warmup.Requests, report.Warmup.Seconds)
+	}
+	if report.Cooldown != nil {
+		fmt.Fprintf(w, "Cool-down (excluded):           %10d hits in %d sec\n", report.Cooldown.Requests, report.Cooldown.Seconds)
+	}
+	fmt.Fprintf(w, "New connections:                %10d\n", report.NewConns)
+	fmt.Fprintf(w, "Reused connections:             %10d\n", report.ReusedConns)
+	fmt.Fprintf(w, "Connections opened/closed:      %10d/%d (%s)\n",
+		report.Connections.Opened, report.Connections.Closed, report.Config.ConnMode)
+	fmt.Fprintf(w, "Connection errors:              %10d\n", report.Connections.Errors)
+	if report.Config.UnixSocket != "" {
+		fmt.Fprintf(w, "Unix socket:                    %s\n", report.Config.UnixSocket)
+	} else if report.Config.Proxy != "" {
+		fmt.Fprintf(w, "Proxy:                          %s\n", report.Config.Proxy)
+	}
+	if report.OpenLoop != nil {
+		fmt.Fprintf(w, "Target request rate:            %10.2f hits/sec (%s)\n",
+			report.OpenLoop.TargetRate, report.Config.ArrivalMode)
+		fmt.Fprintf(w, "Offered request rate:           %10.2f hits/sec\n", report.OpenLoop.OfferedRate)
+		fmt.Fprintf(w, "Delayed requests (>1ms late):   %10d hits\n", report.OpenLoop.Delayed)
+		fmt.Fprintf(w, "Max send delay:                 %10d ms\n", report.OpenLoop.MaxDelay)
+	}
+	if report.Think != nil {
+		fmt.Fprintf(w, "Think time mean/max:            %10.1f/%d ms (%d waits)\n",
+			report.Think.Mean, report.Think.Max, report.Think.Waits)
+		fmt.Fprintf(w, "Request rate per client:        %10.2f hits/sec\n", report.Think.ClientRate)
+	}
+	if report.HTTP2 != nil {
+		fmt.Fprintf(w, "HTTP/2 connections/streams:     %10d/%d (%s)\n",
+			report.HTTP2.Connections, report.HTTP2.Streams, report.Config.Protocol)
+		fmt.Fprintf(w, "Max concurrent streams:         %10d per connection\n", report.HTTP2.MaxStreams)
+	}
+	if tlsReport := report.TLS; tlsReport != nil {
+		fmt.Fprintf(w, "TLS handshakes (resumed):       %10d (%d)\n", tlsReport.Handshakes, tlsReport.Resumed)
+		fmt.Fprintf(w, "TLS handshake failures:         %10d\n", tlsReport.Failed)
+		fmt.Fprintf(w, "TLS handshake mean/95%%/max:     %10.1f/%d/%d us\n", tlsReport.Mean, tlsReport.P95, tlsReport.Max)
+		negotiated := make([]string, 0, len(tlsReport.Negotiated))
+		for name := range tlsReport.Negotiated {
+			negotiated = append(negotiated, name)
+		}
+		sort.Strings(negotiated)
+		for _, name := range negotiated {
+			fmt.Fprintf(w, "TLS negotiated:                 %10d %s\n", tlsReport.Negotiated[name], name)
+		}
+	}
+	if len(report.Identities) > 0 {
+		// the busiest identities first
+		fmt.Fprintf(w, "Identities (min/max requests):  %10d (%d/%d)\n", len(report.Identities),
+			report.Identities[len(report.Identities)-1].Requests, report.Identities[0].Requests)
+		for i := 0; i < len(report.Identities) && i < 5; i++ {
+			fmt.Fprintf(w, "Busiest identity:               %10d hits (%d failed) %s\n", report.Identities[i].Requests,
+				report.Identities[i].Failed, report.Identities[i].User)
+		}
+	}
+	if len(report.Endpoints) > 0 {
+		fmt.Fprintf(w, "\n%-24s %10s %8s %10s %10s %10s %10s %10s (%s, us)\n", "Endpoint", "requests", "failed",
+			"rate(/s)", "mean", "50%", "95%", "max", report.Config.Balance)
+		for _, e := range report.Endpoints {
+			fmt.Fprintf(w, "%-24s %10d %8d %10.2f %10.1f %10d %10d %10d\n", e.Address, e.Requests, e.Failed,
+				e.Rate, e.Mean, e.P50, e.P95, e.Max)
+		}
+		fmt.Fprintln(w)
+	}
+	if report.Sessions != nil {
+		fmt.Fprintf(w, "Completed sessions:             %10d\n", report.Sessions.Completed)
+		fmt.Fprintf(w, "Aborted sessions:               %10d\n", report.Sessions.Aborted)
+		fmt.Fprintf(w, "Incomplete sessions:            %10d\n", report.Sessions.Incomplete)
+	}
+
+	// Time elapsed results
+	fmt.Fprintln(w, "\nPercentage of the requests served within a certain time (ms)")
+	for _, urlReport := range report.URLs {
+		fmt.Fprintln(w, "For URL:", urlReport.URL, "\nTotal", urlReport.Responses, "responses are received")
+		fmt.Fprintf(w, "Success/network failed/!2xx/mismatch: %d/%d/%d/%d hits\n", urlReport.Success,
+			urlReport.NetworkFailed, urlReport.BadFailed, urlReport.Mismatched)
+		for _, pct := range urlReport.Percentiles {
+			fmt.Fprintf(w, "%5s %10d %10d us\n", strconv.FormatFloat(pct.Percentile, 'f', -1, 64)+"%",
+				pct.Value/1000, pct.Value)
+		}
+		fmt.Fprintf(w, "Latency min/mean/max/stddev: %d/%.1f/%d/%.1f us\n",
+			urlReport.Min, urlReport.Mean, urlReport.Max, urlReport.StdDev)
+		fmt.Fprintf(w, "Connections new/reused: %d/%d\n", urlReport.NewConns, urlReport.ReusedConns)
+		if urlReport.Sent != nil {
+			fmt.Fprintf(w, "%-8s %10s %12s %10s %10s %10s %10s %10s (bytes)\n", "Size", "count", "total",
+				"rate(/s)", "mean", "50%", "95%", "max")
+			for _, payload := range []struct {
+				name string
+				*Payload
+			}{{"sent", urlReport.Sent}, {"received", urlReport.Received}} {
+				fmt.Fprintf(w, "%-8s %10d %12d %10d %10.1f %10d %10d %10d\n", payload.name, payload.Count,
+					payload.Total, payload.Rate, payload.Mean, payload.Histogram.ValueAtPercentile(50),
+					payload.Histogram.ValueAtPercentile(95), payload.Max)
+			}
+		}
+		if len(urlReport.Failures) > 0 {
+			fmt.Fprintf(w, "%-22s %10s %12s %12s (sec)\n", "Failure", "count", "first", "last")
+			for _, failure := range urlReport.Failures {
+				fmt.Fprintf(w, "%-22s %10d %12.3f %12.3f\n", failure.name(), failure.Count,
+					failure.FirstSeen.Sub(report.StartTime).Seconds(), failure.LastSeen.Sub(report.StartTime).Seconds())
+			}
+		}
+		if len(urlReport.Phases) > 0 {
+			fmt.Fprintf(w, "%-6s %10s %10s %10s %10s %10s %10s (us)\n", "Phase", "count", "mean", "50%", "95%", "99%", "max")
+			for _, phase := range urlReport.Phases {
+				fmt.Fprintf(w, "%-6s %10d %10.1f %10d %10d %10d %10d\n", phase.Phase, phase.Count, phase.Mean,
+					phase.P50, phase.P95, phase.P99, phase.Max)
+			}
+		}
+
+		if apdex := urlReport.Apdex; apdex != nil {
+			fmt.Fprintf(w, "\nFor time threshold values [%d:%dms]\n", apdex.SatisfiedThreshold, apdex.ToleratedThreshold)
+			fmt.Fprintf(w, "Satisfied requests count:  %10d\n", apdex.Satisfied)
+			fmt.Fprintf(w, "Tolerated requests count:  %10d\n", apdex.Tolerated)
+			fmt.Fprintf(w, "Frustrated requests count: %10d\n", apdex.Frustrated)
+			fmt.Fprintf(w, "Apdex score is: %.5f\n\n", apdex.Score)
+		}
+	}
+
+	if report.SLA != nil {
+		WriteSLA(w, report.SLA)
+	}
+}
+
+// One row for the whole run (URL "*") followed by one row per URL. Rates are
+// only known for the whole run, response times are in microseconds and
+// sizes in bytes.
+func writeCSVReport(w io.Writer, report *Report) error {
+	csvWriter := csv.NewWriter(w)
+	header := []string{"VERSION", "URL", "REQUESTS", "SUCC_REQS", "NET_FAILED", "BAD_REQS", "RESP_MISMATCH",
+		"SUCC_REQS_RATE(REQ/S)", "READ_TP(B/S)", "WRITE_TP(B/S)", "TIME(S)", "RESPONSES",
+		"MIN(US)", "MEAN(US)", "MAX(US)", "STDDEV(US)", "NEW_CONNS", "REUSED_CONNS"}
+	for _, pct := range report.Config.Percentiles {
+		header = append(header, "P"+strconv.FormatFloat(pct, 'f', -1, 64)+"(US)")
+	}
+	header = append(header, "APDEX_T(MS)", "SATISFIED", "TOLERATED", "FRUSTRATED", "APDEX", "FAILURES",
+		"SENT(B)", "SENT_TP(B/S)", "REQ_SIZE_MEAN(B)", "RECEIVED(B)", "RECEIVED_TP(B/S)", "RESP_SIZE_MEAN(B)",
+		"RESP_SIZE_P50(B)", "RESP_SIZE_P95(B)", "RESP_SIZE_MAX(B)")
+	csvWriter.Write(header)
+
+	all := NewHistogram()
+	sent, received := NewHistogram(), NewHistogram()
+	for _, urlReport := range report.URLs {
+		all.Merge(urlReport.Histogram)
+		if urlReport.Sent != nil {
+			sent.Merge(urlReport.Sent.Histogram)
+			received.Merge(urlReport.Received.Histogram)
+		}
+	}
+	row := []string{strconv.Itoa(report.Version), "*",
+		strconv.FormatInt(report.Requests, 10),
+		strconv.FormatInt(report.Success, 10),
+		strconv.FormatInt(report.NetworkFailed, 10),
+		strconv.FormatInt(report.BadFailed, 10),
+		strconv.FormatInt(report.Mismatched, 10),
+		fmt.Sprintf("%.2f", report.SuccessRate),
+		strconv.FormatInt(report.ReadThroughput, 10),
+		strconv.FormatInt(report.WriteThroughput, 10),
+		strconv.FormatInt(report.TestTime, 10)}
+	row = append(row, csvHistogramColumns(all, report.Config.Percentiles)...)
+	row = append(row, strconv.FormatInt(report.NewConns, 10), strconv.FormatInt(report.ReusedConns, 10))
+	row = append(row, "", "", "", "", "", "")
+	csvWriter.Write(append(row, csvPayloadColumns(newPayload(sent, nil, report.TestTime),
+		newPayload(received, nil, report.TestTime))...))
+
+	for _, urlReport := range report.URLs {
+		row := []string{strconv.Itoa(report.Version), urlReport.URL,
+			strconv.FormatInt(urlReport.Requests, 10),
+			strconv.FormatInt(urlReport.Success, 10),
+			strconv.FormatInt(urlReport.NetworkFailed, 10),
+			strconv.FormatInt(urlReport.BadFailed, 10),
+			strconv.FormatInt(urlReport.Mismatched, 10),
+			"", "", "", ""}
+		row = append(row, csvHistogramColumns(urlReport.Histogram, report.Config.Percentiles)...)
+		row = append(row, strconv.FormatInt(urlReport.NewConns, 10), strconv.FormatInt(urlReport.ReusedConns, 10))
+		if apdex := urlReport.Apdex; apdex != nil {
+			row = append(row, fmt.Sprintf("%d:%d", apdex.SatisfiedThreshold, apdex.ToleratedThreshold),
+				strconv.FormatInt(apdex.Satisfied, 10),
+				strconv.FormatInt(apdex.Tolerated, 10),
+				strconv.FormatInt(apdex.Frustrated, 10),
+				fmt.Sprintf("%.5f", apdex.Score))
+		} else {
+			row = append(row, "", "", "", "", "")
+		}
+		var failures []string
+		for _, failure := range urlReport.Failures {
+			failures = append(failures, failure.name()+"="+strconv.FormatInt(failure.Count, 10))
+		}
+		row = append(row, strings.Join(failures, ";"))
+		if urlReport.Sent != nil {
+			row = append(row, csvPayloadColumns(urlReport.Sent, urlReport.Received)...)
+		} else {
+			row = append(row, "", "", "", "", "", "", "", "", "")
+		}
+		csvWriter.Write(row)
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func csvPayloadColumns(sent *Payload, received *Payload) []string {
+	return []string{strconv.FormatInt(sent.Total, 10), strconv.FormatInt(sent.Rate, 10),
+		fmt.Sprintf("%.1f", sent.Mean),
+		strconv.FormatInt(received.Total, 10), strconv.FormatInt(received.Rate, 10),
+		fmt.Sprintf("%.1f", received.Mean),
+		strconv.FormatInt(received.Histogram.ValueAtPercentile(50), 10),
+		strconv.FormatInt(received.Histogram.ValueAtPercentile(95), 10),
+		strconv.FormatInt(received.Max, 10)}
+}
+
+func csvHistogramColumns(hist *Histogram, pcts []float64) []string {
+	columns := []string{strconv.FormatInt(hist.Count(), 10),
+		strconv.FormatInt(hist.Min(), 10),
+		fmt.Sprintf("%.1f", hist.Mean()),
+		strconv.FormatInt(hist.Max(), 10),
+		fmt.Sprintf("%.1f", hist.StdDev())}
+	for _, pct := range pcts {
+		columns = append(columns, strconv.FormatInt(hist.ValueAtPercentile(pct), 10))
+	}
+	return columns
+}
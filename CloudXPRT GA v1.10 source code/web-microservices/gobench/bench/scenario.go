@@ -0,0 +1,402 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// A scenario file lists the steps every client runs in order, for example:
+//
+//	# register a user, then run MC for the same user
+//	STEP:register
+//	http://IP:8070/users[POST]{"id":"user_${client}","password":"pass_${client}"}
+//	[HEADER]Content-Type: application/json
+//	[EXTRACT]name=json:id
+//	STEP:mc
+//	http://IP:8070/mc?name=${name}[EXPECT]Monte[THOLD]500:2000
+//	[CHECK]json:results.*.callresult>0
+//
+// The request line takes [POST], [EXPECT], [THOLD], [THINK] and [SLA] like the URL
+// file, the think time of a step is waited before the next step.
+// [METHOD] overrides the method, [HEADER] adds a header and [EXTRACT] stores
+// a value of the response into a variable with json:path, regex:expression
+// (first group or whole match) or header:name. [CHECK] adds validation
+// rules of the step (see validate.go). ${variable} is replaced in
+// URLs, headers, bodies and patterns, along with the functions of templates
+// (see template.go).
+type Step struct {
+	name     string
+	method   string
+	url      string
+	body     string
+	pattern  string
+	headers  [][2]string
+	extracts []*Extract
+	think    *thinkTime
+	// validation rules of the step, nil for the rules of all URLs
+	checks []*check
+}
+
+type Extract struct {
+	variable string
+	kind     string
+	expr     string
+	re       *regexp.Regexp
+}
+
+// Outcome of the scenario sessions of one client
+type sessionResult struct {
+	started   int64
+	completed int64
+	aborted   int64
+}
+
+func (configuration *Configuration) readScenario(path string) (steps []*Step, err error) {
+	var file *os.File
+	var step *Step
+
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	names := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		temp := strings.TrimSpace(scanner.Text())
+		if len(temp) == 0 || strings.HasPrefix(temp, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(temp, "STEP:") {
+			// Format of this line is: STEP:login
+			name := strings.TrimSpace(strings.TrimPrefix(temp, "STEP:"))
+			if _, ok := names[name]; ok || len(name) == 0 {
+				return nil, fmt.Errorf("empty or duplicate step name %q", name)
+			}
+			names[name] = struct{}{}
+			step = &Step{name: name}
+			steps = append(steps, step)
+			continue
+		}
+
+		if step == nil {
+			return nil, fmt.Errorf("%q does not belong to a STEP", temp)
+		}
+
+		switch {
+		case strings.HasPrefix(temp, "[METHOD]"):
+			step.method = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(temp, "[METHOD]")))
+		case strings.HasPrefix(temp, "[HEADER]"):
+			tokens := strings.SplitN(strings.TrimPrefix(temp, "[HEADER]"), ":", 2)
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("invalid header %q in step %s", temp, step.name)
+			}
+			step.headers = append(step.headers,
+				[2]string{strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1])})
+		case strings.HasPrefix(temp, "[EXTRACT]"):
+			extract, err := parseExtract(strings.TrimPrefix(temp, "[EXTRACT]"))
+			if err != nil {
+				return nil, fmt.Errorf("%s in step %s", err.Error(), step.name)
+			}
+			step.extracts = append(step.extracts, extract)
+		case strings.HasPrefix(temp, "[CHECK]"):
+			checks, err := parseChecks(strings.TrimPrefix(temp, "[CHECK]"))
+			if err != nil {
+				return nil, fmt.Errorf("%s in step %s", err.Error(), step.name)
+			}
+			step.checks = append(step.checks, checks...)
+		default:
+			if len(step.url) > 0 {
+				return nil, fmt.Errorf("step %s has more than one request line", step.name)
+			}
+			if err := configuration.parseStepRequest(step, temp); err != nil {
+				return nil, fmt.Errorf("%s in step %s", err.Error(), step.name)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no STEP found")
+	}
+	for _, step := range steps {
+		if len(step.url) == 0 {
+			return nil, fmt.Errorf("step %s has no request line", step.name)
+		}
+		templates := []string{step.url, step.body, step.pattern}
+		for _, header := range step.headers {
+			templates = append(templates, header[1])
+		}
+		for _, template := range templates {
+			if err := configuration.checkTemplate(template); err != nil {
+				return nil, fmt.Errorf("%s in step %s", err.Error(), step.name)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// In the format of URL[POST]body[EXPECT]pattern[THOLD]lower:upper[THINK]time[SLA]rules
+func (configuration *Configuration) parseStepRequest(step *Step, temp string) error {
+	if strings.Contains(temp, "[THINK]") {
+		var spec string
+		var err error
+		temp, spec = cutToken(temp, "[THINK]")
+		if step.think, err = parseThink(spec); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(temp, "[SLA]") {
+		var spec string
+		temp, spec = cutToken(temp, "[SLA]")
+		rules, err := parseSLA(spec)
+		if err != nil {
+			return err
+		}
+		configuration.slaRules[step.name] = rules
+	}
+	if strings.Contains(temp, "[THOLD]") {
+		results := strings.Split(temp, "[THOLD]")
+		configuration.thresholds[step.name] = results[1]
+		temp = results[0]
+	}
+	if strings.Contains(temp, "[EXPECT]") {
+		results := strings.Split(temp, "[EXPECT]")
+		step.pattern = results[1]
+		temp = results[0]
+	} else {
+		step.pattern = configuration.expect
+	}
+	if strings.Contains(temp, "[POST]") {
+		results := strings.Split(temp, "[POST]")
+		step.body = results[1]
+		temp = results[0]
+		if len(step.method) == 0 {
+			step.method = "POST"
+		}
+	}
+	if len(step.method) == 0 {
+		step.method = "GET"
+	}
+	step.url = temp
+	return nil
+}
+
+// In the format of variable=json:path, variable=regex:expression or
+// variable=header:name
+func parseExtract(input string) (*Extract, error) {
+	tokens := strings.SplitN(input, "=", 2)
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("invalid extract %q", input)
+	}
+	rule := strings.SplitN(tokens[1], ":", 2)
+	if len(rule) != 2 {
+		return nil, fmt.Errorf("invalid extract %q", input)
+	}
+
+	extract := &Extract{variable: strings.TrimSpace(tokens[0]), kind: rule[0], expr: rule[1]}
+	switch extract.kind {
+	case "json", "header":
+	case "regex":
+		re, err := regexp.Compile(extract.expr)
+		if err != nil {
+			return nil, err
+		}
+		extract.re = re
+	default:
+		return nil, fmt.Errorf("unknown extract type %q", extract.kind)
+	}
+	return extract, nil
+}
+
+func extractValue(extract *Extract, resp *fasthttp.Response) (string, bool) {
+	switch extract.kind {
+	case "json":
+		return jsonPath(resp.Body(), extract.expr)
+	case "header":
+		value := resp.Header.Peek(extract.expr)
+		return string(value), value != nil
+	default:
+		match := extract.re.FindSubmatch(resp.Body())
+		if match == nil {
+			return "", false
+		}
+		return string(match[len(match)-1]), true
+	}
+}
+
+// Look up a value by a dotted path such as $.results[0].callresult or
+// results.0.callresult, strings are returned without quotes
+func jsonPath(body []byte, path string) (string, bool) {
+	node, ok := decodeJSON(body)
+	if !ok {
+		return "", false
+	}
+	nodes := jsonLookup(node, jsonKeys(path))
+	if len(nodes) == 0 || nodes[0] == nil {
+		return "", false
+	}
+	return jsonString(nodes[0]), true
+}
+
+func decodeJSON(body []byte) (interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return nil, false
+	}
+	return node, true
+}
+
+// Keys of a dotted path, indexes in brackets become keys
+func jsonKeys(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.Replace(strings.Replace(path, "[", ".", -1), "]", "", -1)
+	var keys []string
+	for _, key := range strings.Split(path, ".") {
+		if len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Values at keys below node, * takes all elements of an array or object
+func jsonLookup(node interface{}, keys []string) []interface{} {
+	if len(keys) == 0 {
+		return []interface{}{node}
+	}
+	key := keys[0]
+	var children []interface{}
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if key == "*" {
+			for _, child := range value {
+				children = append(children, child)
+			}
+		} else if child, ok := value[key]; ok {
+			children = append(children, child)
+		}
+	case []interface{}:
+		if key == "*" {
+			children = value
+		} else if index, err := strconv.Atoi(key); err == nil && index >= 0 && index < len(value) {
+			children = append(children, value[index])
+		}
+	}
+
+	var nodes []interface{}
+	for _, child := range children {
+		nodes = append(nodes, jsonLookup(child, keys[1:])...)
+	}
+	return nodes
+}
+
+// Run all steps of the scenario once. A step that fails or does not yield
+// the values to extract aborts the session, as a real user could not go on.
+// A session cut off by the end of the run is neither completed nor aborted.
+func runScenario(configuration *Configuration, vc *virtualClient, start time.Time) {
+	vars := make(map[string]string)
+	measured := vc.run.window.of(start) == windowMeasured
+	count := func(counter *int64) {
+		if measured {
+			vc.result.mu.Lock()
+			*counter++
+			vc.result.mu.Unlock()
+		}
+	}
+	count(&vc.result.sessions.started)
+
+	for i, step := range configuration.scenario {
+		if i > 0 {
+			// the run ended during the think time
+			if vc.ctx.Err() != nil {
+				return
+			}
+			start = time.Now()
+		}
+
+		vc.login(configuration)
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(configuration.expandVars(step.url, vc, vars))
+		req.Header.SetMethod(step.method)
+		if len(step.body) > 0 {
+			req.SetBodyString(configuration.expandVars(step.body, vc, vars))
+		}
+		setCommonHeaders(configuration, vc, req)
+		for _, header := range step.headers {
+			req.Header.Set(header[0], configuration.expandVars(header[1], vc, vars))
+		}
+
+		checks := configuration.check
+		if step.checks != nil {
+			checks = step.checks
+		}
+		resp, ok := sendRequest(configuration, vc, step.name, req,
+			[]byte(configuration.expandVars(step.pattern, vc, vars)), checks, start)
+		fasthttp.ReleaseRequest(req)
+
+		if ok {
+			for _, extract := range step.extracts {
+				value, found := extractValue(extract, resp)
+				if !found {
+					configuration.debugf("nothing to extract for %s in step %s\n", extract.variable, step.name)
+					ok = false
+					break
+				}
+				vars[extract.variable] = value
+			}
+		}
+		if resp != nil {
+			fasthttp.ReleaseResponse(resp)
+		}
+
+		if !ok {
+			// no response when the end of the run cut the request off
+			if resp != nil || vc.ctx.Err() == nil {
+				count(&vc.result.sessions.aborted)
+			}
+			return
+		}
+
+		if i < len(configuration.scenario)-1 {
+			think := configuration.think
+			if step.think != nil {
+				think = step.think
+			}
+			pause(configuration, vc, think, time.Time{})
+		}
+	}
+
+	count(&vc.result.sessions.completed)
+}
@@ -0,0 +1,67 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := []struct {
+		scenario string
+		aborted  bool
+	}{
+		// every session thinks past the end of the run, incomplete
+		{"STEP:login\n" + server.URL + "/login[THINK]5000\nSTEP:mc\n" + server.URL + "/mc\n", false},
+		// every session fails at its second step
+		{"STEP:login\n" + server.URL + "/login\nSTEP:mc\n" + server.URL + "/mc[EXPECT]Sudoku\n", true},
+	}
+	for i, e := range expected {
+		path := filepath.Join(dir, "scenario"+string(rune('0'+i)))
+		if err := ioutil.WriteFile(path, []byte(e.scenario), 0644); err != nil {
+			t.Fatal(err)
+		}
+		report, err := Run(context.Background(), Config{ScenarioFile: path, Clients: 2,
+			Period: 300 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions := report.Sessions
+		if sessions == nil || sessions.Completed != 0 || (sessions.Aborted > 0) != e.aborted ||
+			(!e.aborted && sessions.Incomplete == 0) {
+			t.Fatalf("Wrong sessions of %q: %+v", e.scenario, sessions)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SLA rules are checked for every URL (or scenario step) at the end of the
+// run. They are comma separated, for example
+//
+//	p95<=500,p99.9<=2000,errors<=1%,rate>=100,apdex>=0.9
+//
+// where pN is the Nth percentile of the response time and mean and max its
+// mean and maximum in milliseconds, errors the failed requests in percent
+// of all requests, rate the successful requests per second and apdex the
+// Apdex score, which needs a time threshold. Rules of a URL or step set with
+// [SLA] replace the rules of all URLs (Config.SLA).
+type slaRule struct {
+	text   string
+	metric string
+	pct    float64
+	op     string
+	limit  float64
+}
+
+// Verdict of the SLA rules of a run, Passed unless a rule was violated
+type SLAReport struct {
+	Passed   bool        `json:"passed"`
+	Violated int         `json:"violated"`
+	Results  []SLAResult `json:"results"`
+}
+
+// One rule of one URL, Value is in the unit of the rule
+type SLAResult struct {
+	URL    string  `json:"url"`
+	Rule   string  `json:"rule"`
+	Value  float64 `json:"value"`
+	Passed bool    `json:"passed"`
+	// why the value is missing, such as a URL without requests
+	Reason string `json:"reason,omitempty"`
+}
+
+var slaOps = []string{"<=", ">=", "<", ">"}
+
+func parseSLA(spec string) ([]*slaRule, error) {
+	var rules []*slaRule
+	for _, text := range strings.Split(spec, ",") {
+		text = strings.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		rule := &slaRule{text: text}
+		var value string
+		for _, op := range slaOps {
+			if i := strings.Index(text, op); i > 0 {
+				rule.metric, rule.op, value = strings.TrimSpace(text[:i]), op, strings.TrimSpace(text[i+len(op):])
+				break
+			}
+		}
+		if rule.op == "" {
+			return nil, fmt.Errorf("invalid SLA rule %q", text)
+		}
+
+		switch {
+		case rule.metric == "mean", rule.metric == "max", rule.metric == "errors", rule.metric == "rate",
+			rule.metric == "apdex":
+		case strings.HasPrefix(rule.metric, "p"):
+			pct, err := strconv.ParseFloat(rule.metric[1:], 64)
+			if err != nil || pct < 0 || pct > 100 {
+				return nil, fmt.Errorf("invalid percentile in SLA rule %q", text)
+			}
+			rule.pct = pct
+		default:
+			return nil, fmt.Errorf("unknown metric in SLA rule %q", text)
+		}
+
+		unit := "ms"
+		switch rule.metric {
+		case "errors":
+			unit = "%"
+		case "rate", "apdex":
+			unit = ""
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSuffix(value, unit), 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit in SLA rule %q", text)
+		}
+		rule.limit = limit
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no SLA rule in %q", spec)
+	}
+	return rules, nil
+}
+
+func (rule *slaRule) check(value float64) bool {
+	switch rule.op {
+	case "<=":
+		return value <= rule.limit
+	case ">=":
+		return value >= rule.limit
+	case "<":
+		return value < rule.limit
+	default:
+		return value > rule.limit
+	}
+}
+
+// Rules of key, nil without any
+func (configuration *Configuration) slaOf(key string) []*slaRule {
+	if rules, ok := configuration.slaRules[key]; ok {
+		return rules
+	}
+	return configuration.sla
+}
+
+// Apdex rules need a time threshold of their URL or of all URLs. Rules of
+// all URLs are accepted when some URLs have thresholds of their own.
+func (configuration *Configuration) checkSLA() error {
+	hasThreshold := func(key string) bool {
+		if _, ok := configuration.thresholds[key]; ok {
+			return true
+		}
+		return strings.Contains(configuration.timeThreshold, ":")
+	}
+	for _, rule := range configuration.sla {
+		if rule.metric == "apdex" && !hasThreshold("") && len(configuration.thresholds) == 0 {
+			return fmt.Errorf("SLA rule %s needs a time threshold", rule.text)
+		}
+	}
+	for key, rules := range configuration.slaRules {
+		for _, rule := range rules {
+			if rule.metric == "apdex" && !hasThreshold(key) {
+				return fmt.Errorf("SLA rule %s of %s needs a time threshold", rule.text, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Check the SLA rules against the statistics of the report, nil without rules
+func (configuration *Configuration) evaluateSLA(report *Report) *SLAReport {
+	if len(configuration.sla) == 0 && len(configuration.slaRules) == 0 {
+		return nil
+	}
+	sla := &SLAReport{Passed: true, Results: make([]SLAResult, 0)}
+	add := func(result SLAResult) {
+		if !result.Passed {
+			sla.Passed = false
+			sla.Violated++
+		}
+		sla.Results = append(sla.Results, result)
+	}
+
+	seen := make(map[string]bool)
+	for _, urlReport := range report.URLs {
+		seen[urlReport.URL] = true
+		for _, rule := range configuration.slaOf(urlReport.URL) {
+			result := SLAResult{URL: urlReport.URL, Rule: rule.text}
+			hist := urlReport.Histogram
+			switch {
+			case rule.metric == "mean":
+				result.Value = hist.Mean() / 1000
+			case rule.metric == "max":
+				result.Value = float64(hist.Max()) / 1000
+			case rule.metric == "errors":
+				if urlReport.Requests > 0 {
+					result.Value = float64(urlReport.Requests-urlReport.Success) * 100 / float64(urlReport.Requests)
+				}
+			case rule.metric == "rate":
+				if report.TestTime > 0 {
+					result.Value = float64(urlReport.Success) / float64(report.TestTime)
+				}
+			case rule.metric == "apdex":
+				if urlReport.Apdex == nil {
+					result.Reason = "no time threshold"
+					add(result)
+					continue
+				}
+				result.Value = urlReport.Apdex.Score
+			default:
+				result.Value = float64(hist.ValueAtPercentile(rule.pct)) / 1000
+			}
+			if hist.Count() == 0 && rule.metric != "errors" && rule.metric != "rate" {
+				result.Reason = "no response"
+				add(result)
+				continue
+			}
+			result.Passed = rule.check(result.Value)
+			add(result)
+		}
+	}
+
+	// URLs with rules of their own have to be requested
+	keys := make([]string, 0, len(configuration.slaRules))
+	for key := range configuration.slaRules {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, rule := range configuration.slaRules[key] {
+			add(SLAResult{URL: key, Rule: rule.text, Reason: "no request"})
+		}
+	}
+	return sla
+}
+
+// Write the verdict of the SLA rules as a table
+func WriteSLA(w io.Writer, sla *SLAReport) {
+	verdict := "PASS"
+	if !sla.Passed {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(w, "\nSLA verdict: %s (%d of %d rules violated)\n", verdict, sla.Violated, len(sla.Results))
+	fmt.Fprintf(w, "%-6s %-24s %12s  %s\n", "Result", "Rule", "value", "URL")
+	for _, result := range sla.Results {
+		verdict, value := "pass", strconv.FormatFloat(result.Value, 'f', 3, 64)
+		if !result.Passed {
+			verdict = "FAIL"
+		}
+		if result.Reason != "" {
+			value = result.Reason
+		}
+		fmt.Fprintf(w, "%-6s %-24s %12s  %s\n", verdict, result.Rule, value, result.URL)
+	}
+}
@@ -0,0 +1,111 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSLA(t *testing.T) {
+	rules, err := parseSLA("p99.9<=2000ms, errors<1%,rate>=100,apdex>0.9,mean<=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 || rules[0].pct != 99.9 || rules[0].op != "<=" || rules[0].limit != 2000 ||
+		rules[1].metric != "errors" || rules[1].op != "<" || rules[1].limit != 1 || rules[3].op != ">" {
+		t.Fatalf("Wrong rules %+v %+v %+v %+v", rules[0], rules[1], rules[2], rules[3])
+	}
+
+	for _, invalid := range []string{"", "p95", "p101<=5", "px<=5", "latency<=5", "p95<=fast", "errors<=-1%"} {
+		if _, err := parseSLA(invalid); err == nil {
+			t.Fatalf("%q should be invalid", invalid)
+		}
+	}
+}
+
+func TestEvaluateSLA(t *testing.T) {
+	hist := NewHistogram()
+	for i := int64(1); i <= 100; i++ {
+		hist.Record(i * 1000)
+	}
+	report := &Report{TestTime: 10, URLs: []URLReport{
+		{URL: "/mc", Requests: 100, Success: 98, Histogram: hist},
+		{URL: "/empty", Histogram: NewHistogram()}}}
+
+	configuration := &Configuration{slaRules: make(map[string][]*slaRule)}
+	if configuration.evaluateSLA(report) != nil {
+		t.Fatal("A run without rules should not have a verdict")
+	}
+
+	configuration.sla, _ = parseSLA("p50<=60,errors<=1%")
+	configuration.slaRules["/empty"], _ = parseSLA("rate>=1")
+	configuration.slaRules["/missing"], _ = parseSLA("p95<=1")
+	sla := configuration.evaluateSLA(report)
+	if sla.Passed || sla.Violated != 3 || len(sla.Results) != 4 {
+		t.Fatalf("Wrong verdict %+v", sla)
+	}
+	expected := []struct {
+		url    string
+		passed bool
+		value  float64
+		reason string
+	}{{"/mc", true, 50, ""}, {"/mc", false, 2, ""}, {"/empty", false, 0, ""}, {"/missing", false, 0, "no request"}}
+	// the histogram keeps values to below 1.6%
+	for i, result := range sla.Results {
+		if result.URL != expected[i].url || result.Passed != expected[i].passed ||
+			math.Abs(result.Value-expected[i].value) > 1 || result.Reason != expected[i].reason {
+			t.Fatalf("Wrong result %d %+v", i, result)
+		}
+	}
+}
+
+func TestURLFileSLA(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Monte Carlo"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "urls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a URL without a path is reported with one
+	path := filepath.Join(dir, "urls.txt")
+	content := "WEIGHT:1\n" + server.URL + "[SLA]p95<=1000,errors<=0%[THOLD]10:40\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(context.Background(), Config{URLFile: path, Clients: 2, Requests: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SLA == nil || !report.SLA.Passed || len(report.SLA.Results) != 2 || report.SLA.Results[0].URL != server.URL+"/" {
+		t.Fatalf("Wrong verdict %+v", report.SLA)
+	}
+	if len(report.URLs) != 1 || report.URLs[0].Apdex == nil {
+		t.Fatalf("No Apdex score of the URL threshold %+v", report.URLs)
+	}
+}
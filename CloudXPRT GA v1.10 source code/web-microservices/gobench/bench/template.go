@@ -0,0 +1,204 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// URLs, bodies (of [POST] and -d), headers and expected patterns are
+// templates, ${...} is replaced for every request by
+//
+//	${client}              number of the client
+//	${randInt(min,max)}    random integer between min and max, both included
+//	${seq} or ${seq(name)} counter shared by all clients of a process, from 1
+//	${csv(path,column)}    random value of a column of a CSV file, the first
+//	                       row of the file names the columns
+//	${uuid}                random UUID (version 4)
+//	${timestamp}           Unix time in seconds, ${timestamp(ms)} in
+//	                       milliseconds or ${timestamp(rfc3339)}
+//	${user.column}         value of the identity of the client, or of its
+//	                       login (see identity.go)
+//
+// and in scenarios by the variables extracted from responses. Random values
+// come from a generator of each client seeded with the seed plus the client
+// number, so a run with the same seed sends the same values. CSV files are
+// read at start and have to exist where the clients run, on agents too.
+var templatePattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.]+)(?:\(([^)]*)\))?\}`)
+
+// Check the functions used in a template and load the CSV columns and
+// counters it needs before the clients start
+func (configuration *Configuration) checkTemplate(input string) error {
+	if !strings.Contains(input, "${") {
+		return nil
+	}
+	for _, match := range templatePattern.FindAllStringSubmatch(input, -1) {
+		name, args := match[1], splitArgs(match[2])
+		switch name {
+		case "randInt":
+			if len(args) != 2 {
+				return fmt.Errorf("%s needs min and max", match[0])
+			}
+			low, err1 := strconv.ParseInt(args[0], 10, 64)
+			high, err2 := strconv.ParseInt(args[1], 10, 64)
+			if err1 != nil || err2 != nil || high < low {
+				return fmt.Errorf("invalid range in %s", match[0])
+			}
+		case "seq":
+			key := strings.Join(args, ",")
+			if _, ok := configuration.seqCounters[key]; !ok {
+				configuration.seqCounters[key] = new(int64)
+			}
+		case "csv":
+			if len(args) != 2 {
+				return fmt.Errorf("%s needs a path and a column", match[0])
+			}
+			if err := configuration.loadCSVColumn(args[0], args[1]); err != nil {
+				return fmt.Errorf("%s: %s", match[0], err.Error())
+			}
+		case "timestamp":
+			if len(args) > 1 || (len(args) == 1 && args[0] != "ms" && args[0] != "rfc3339") {
+				return fmt.Errorf("unknown format in %s", match[0])
+			}
+		case "uuid", "client":
+		default:
+			if strings.HasPrefix(name, "user.") && !configuration.hasUserValue(strings.TrimPrefix(name, "user.")) {
+				return fmt.Errorf("no user pool column or login value for %s", match[0])
+			}
+			// variables of scenarios take no arguments
+			if match[2] != "" {
+				return fmt.Errorf("unknown function in %s", match[0])
+			}
+		}
+	}
+	return nil
+}
+
+func splitArgs(input string) []string {
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+	args := strings.Split(input, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
+
+func (configuration *Configuration) loadCSVColumn(path string, column string) error {
+	key := path + "," + column
+	if _, ok := configuration.csvColumns[key]; ok {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) < 2 {
+		return fmt.Errorf("no values in %s", path)
+	}
+	index := -1
+	for i, name := range records[0] {
+		if strings.TrimSpace(name) == column {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("no column %s in %s", column, path)
+	}
+
+	var values []string
+	for _, record := range records[1:] {
+		if index < len(record) {
+			values = append(values, record[index])
+		}
+	}
+	configuration.csvColumns[key] = values
+	return nil
+}
+
+// Replace the variables and functions of a template for a request of vc
+func (configuration *Configuration) expandVars(input string, vc *virtualClient, vars map[string]string) string {
+	if !strings.Contains(input, "${") {
+		return input
+	}
+	return templatePattern.ReplaceAllStringFunc(input, func(match string) string {
+		groups := templatePattern.FindStringSubmatch(match)
+		name, args := groups[1], splitArgs(groups[2])
+		if value, ok := vars[name]; ok && groups[2] == "" {
+			return value
+		}
+
+		switch name {
+		case "client":
+			return strconv.Itoa(configuration.firstClient + vc.id)
+		case "randInt":
+			low, _ := strconv.ParseInt(args[0], 10, 64)
+			high, _ := strconv.ParseInt(args[1], 10, 64)
+			return strconv.FormatInt(low+vc.rng.Int63n(high-low+1), 10)
+		case "seq":
+			if counter, ok := configuration.seqCounters[strings.Join(args, ",")]; ok {
+				return strconv.FormatInt(atomic.AddInt64(counter, 1), 10)
+			}
+		case "csv":
+			if values, ok := configuration.csvColumns[args[0]+","+args[1]]; ok {
+				return values[vc.rng.Intn(len(values))]
+			}
+		case "uuid":
+			return newUUID(vc.rng)
+		case "timestamp":
+			now := time.Now()
+			switch {
+			case len(args) == 0:
+				return strconv.FormatInt(now.Unix(), 10)
+			case args[0] == "ms":
+				return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+			default:
+				return now.Format(time.RFC3339)
+			}
+		default:
+			if vc.identity != nil && strings.HasPrefix(name, "user.") {
+				if value, ok := vc.identity.values[strings.TrimPrefix(name, "user.")]; ok {
+					return value
+				}
+			}
+		}
+		return match
+	})
+}
+
+func newUUID(rng *rand.Rand) string {
+	var b [16]byte
+	rng.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,58 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	path := writeReplayFile(t, "id,name\n1,alice\n2,bob\n")
+	template := "/mc?name=${csv(" + path + ",name)}&n=${randInt(5,7)}&seq=${seq(t)}&c=${client}&v=${user}&x=${nope}"
+	configuration := &Configuration{csvColumns: make(map[string][]string), seqCounters: make(map[string]*int64)}
+	if err := configuration.checkTemplate(template + "${uuid}${timestamp(ms)}"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := regexp.MustCompile(`^/mc\?name=(alice|bob)&n=[5-7]&seq=1&c=3&v=u7&x=\$\{nope\}$`)
+	vc := &virtualClient{id: 3, rng: rand.New(rand.NewSource(1))}
+	first := configuration.expandVars(template, vc, map[string]string{"user": "u7"})
+	if !expected.MatchString(first) {
+		t.Fatalf("Wrong expansion %s", first)
+	}
+
+	// the same seed gives the same values, apart from the counter
+	vc.rng = rand.New(rand.NewSource(1))
+	second := configuration.expandVars(template, vc, map[string]string{"user": "u7"})
+	counter := regexp.MustCompile(`seq=\d+`)
+	if counter.ReplaceAllString(first, "") != counter.ReplaceAllString(second, "") {
+		t.Fatalf("Clients with the same seed should draw the same values: %s %s", first, second)
+	}
+
+	uuid := configuration.expandVars("${uuid}", vc, nil)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid) {
+		t.Fatalf("Wrong UUID %s", uuid)
+	}
+
+	for _, invalid := range []string{"${randInt(9,1)}", "${csv(" + path + ",age)}", "${timestamp(days)}", "${foo(1)}"} {
+		if err := configuration.checkTemplate(invalid); err == nil {
+			t.Fatalf("%s should be invalid", invalid)
+		}
+	}
+}
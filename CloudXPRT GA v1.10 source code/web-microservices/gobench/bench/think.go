@@ -0,0 +1,147 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Think time of a client after a request, in one of the formats
+//
+//	500             constant 500 ms
+//	uniform:200-800 uniform between 200 and 800 ms
+//	exp:500         exponential with a mean of 500 ms
+type thinkTime struct {
+	dist string
+	low  time.Duration
+	high time.Duration
+}
+
+func parseThink(spec string) (*thinkTime, error) {
+	spec = strings.TrimSpace(spec)
+	dist := "constant"
+	if tokens := strings.SplitN(spec, ":", 2); len(tokens) == 2 {
+		dist, spec = tokens[0], tokens[1]
+	}
+
+	values := []string{spec}
+	if dist == "uniform" {
+		values = strings.SplitN(spec, "-", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid uniform think time %q", spec)
+		}
+	}
+	var durations []time.Duration
+	for _, value := range values {
+		ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("invalid think time %q", value)
+		}
+		durations = append(durations, time.Duration(ms*float64(time.Millisecond)))
+	}
+
+	think := &thinkTime{dist: dist, low: durations[0], high: durations[0]}
+	switch dist {
+	case "constant", "exp":
+	case "uniform":
+		think.high = durations[1]
+		if think.high < think.low {
+			return nil, fmt.Errorf("invalid uniform think time %q", spec)
+		}
+	default:
+		return nil, fmt.Errorf("unknown think time distribution %q", dist)
+	}
+	return think, nil
+}
+
+func (t *thinkTime) next(rng *rand.Rand) time.Duration {
+	switch t.dist {
+	case "uniform":
+		return t.low + time.Duration(rng.Int63n(int64(t.high-t.low)+1))
+	case "exp":
+		return time.Duration(rng.ExpFloat64() * float64(t.low))
+	default:
+		return t.low
+	}
+}
+
+// Remove [TOKEN]value from line, the value ends at the next [ or the end
+func cutToken(line string, token string) (string, string) {
+	start := strings.Index(line, token)
+	if start < 0 {
+		return line, ""
+	}
+	value := line[start+len(token):]
+	end := strings.Index(value, "[")
+	if end < 0 {
+		end = len(value)
+	}
+	return line[:start] + value[end:], value[:end]
+}
+
+// Options following the URL on a line of the URL file
+var lineOptions = []string{"[EXPECT]", "[POST]", "[CHECK]", "[THINK]", "[SLA]", "[THOLD]"}
+
+// URL of a line of the URL file, without the [...] options. The URL ends at
+// the first option, as IPv6 hosts are in brackets too.
+func urlOfLine(line string) string {
+	end := len(line)
+	for _, option := range lineOptions {
+		if idx := strings.Index(line, option); idx >= 0 && idx < end {
+			end = idx
+		}
+	}
+	return line[:end]
+}
+
+// Wait after a request or scenario step. The think time comes first, and
+// when start is set the client also waits for the rest of the pacing
+// interval since start, whichever is longer. The wait ends early when the
+// run does.
+func pause(configuration *Configuration, vc *virtualClient, think *thinkTime, start time.Time) {
+	var wait time.Duration
+	if think != nil {
+		wait = think.next(vc.rng)
+	}
+	if configuration.pace > 0 && !start.IsZero() {
+		if rest := configuration.pace - time.Since(start); rest > wait {
+			wait = rest
+		}
+	}
+	if wait <= 0 {
+		return
+	}
+
+	measured := vc.run.window.of(time.Now()) == windowMeasured
+	if !sleepUntil(vc.ctx, time.Now().Add(wait)) {
+		return
+	}
+
+	if measured {
+		vc.result.mu.Lock()
+		vc.result.waits++
+		vc.result.waited += wait
+		if wait > vc.result.maxWait {
+			vc.result.maxWait = wait
+		}
+		vc.result.mu.Unlock()
+	}
+}
@@ -0,0 +1,71 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestURLOfLine(t *testing.T) {
+	expected := []struct {
+		line string
+		url  string
+	}{
+		{"http://10.0.0.1:8070/mc", "http://10.0.0.1:8070/mc"},
+		{"http://10.0.0.1:8070/mc[THINK]100[SLA]p95<=5", "http://10.0.0.1:8070/mc"},
+		{"http://[::1]:8070/mc", "http://[::1]:8070/mc"},
+		{"http://[::1]:8070/mc[THINK]exp:100", "http://[::1]:8070/mc"},
+		{"http://[fe80::1]:8070/mc[POST]a=[1][CHECK]status=2xx", "http://[fe80::1]:8070/mc"},
+	}
+	for _, e := range expected {
+		if url := urlOfLine(e.line); url != e.url {
+			t.Fatalf("URL of %q is %q, expected %q", e.line, url, e.url)
+		}
+	}
+}
+
+func TestReadLinesIPv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "urls.txt")
+	content := "WEIGHT:1\nhttp://[::1]:8070/mc[THINK]100[CHECK]status=2xx\nhttp://[::1]:8071/ocr[THINK]200\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configuration := &Configuration{thresholds: make(map[string]string), thinkTimes: make(map[string]*thinkTime),
+		slaRules: make(map[string][]*slaRule), checks: make(map[string][]*check)}
+	if _, err := configuration.readLines(path); err != nil {
+		t.Fatal(err)
+	}
+	// the options of every URL stay its own
+	mc, ocr := configuration.thinkTimes["http://[::1]:8070/mc"], configuration.thinkTimes["http://[::1]:8071/ocr"]
+	if len(configuration.thinkTimes) != 2 || mc == nil || mc.low != 100*time.Millisecond || ocr == nil ||
+		ocr.low != 200*time.Millisecond {
+		t.Fatalf("Wrong think times %+v", configuration.thinkTimes)
+	}
+	if len(configuration.checks) != 1 || len(configuration.checks["http://[::1]:8070/mc"]) != 1 {
+		t.Fatalf("Wrong checks %+v", configuration.checks)
+	}
+}
@@ -0,0 +1,242 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Time series of a run (Config.SeriesInterval): requests are counted by URL
+// in intervals since the start, by the time they finished. Unlike the
+// report it covers the whole run, warm-up and cool-down included, so every
+// interval is labeled with the window it started in.
+type seriesBucket struct {
+	requests      int64
+	success       int64
+	networkFailed int64
+	badFailed     int64
+	mismatched    int64
+	hist          *Histogram
+}
+
+type seriesKey struct {
+	interval int64
+	url      string
+}
+
+// Buckets of all clients by interval and URL. Clients keep the buckets of
+// their current interval in their Result and only move them here when they
+// get to the next interval, so they rarely lock it.
+type seriesState struct {
+	sync.Mutex
+	start    time.Time
+	interval time.Duration
+	buckets  map[seriesKey]*seriesBucket
+}
+
+// One row of the time series, response times are in microseconds
+type SeriesPoint struct {
+	Time          time.Time    `json:"time"`
+	Offset        float64      `json:"offset"`
+	Window        string       `json:"window"`
+	URL           string       `json:"url"`
+	Requests      int64        `json:"requests"`
+	Success       int64        `json:"success"`
+	NetworkFailed int64        `json:"network_failed"`
+	BadFailed     int64        `json:"bad_failed"`
+	Mismatched    int64        `json:"mismatched"`
+	Rate          float64      `json:"rate"`
+	Mean          float64      `json:"mean"`
+	Max           int64        `json:"max"`
+	Percentiles   []Percentile `json:"percentiles"`
+}
+
+var windowNames = []string{"warmup", "cooldown", "measured"}
+
+func (r *Runner) startSeries(start time.Time) {
+	r.series.start = start
+	r.series.interval = r.configuration.seriesInterval
+}
+
+func (b *seriesBucket) add(outcome int, elapsed time.Duration) {
+	b.requests++
+	switch outcome {
+	case outcomeSuccess:
+		b.success++
+	case outcomeNetworkFailed:
+		b.networkFailed++
+	case outcomeBadFailed:
+		b.badFailed++
+	case outcomeMismatched:
+		b.mismatched++
+	}
+	b.hist.Record(int64(elapsed / time.Microsecond))
+}
+
+func (b *seriesBucket) merge(other *seriesBucket) {
+	b.requests += other.requests
+	b.success += other.success
+	b.networkFailed += other.networkFailed
+	b.badFailed += other.badFailed
+	b.mismatched += other.mismatched
+	b.hist.Merge(other.hist)
+}
+
+// Count a request in the time series, with result.mu held
+func (r *Runner) recordSeries(result *Result, key string, info *requestInfo) {
+	if r.series.interval <= 0 {
+		return
+	}
+	interval := int64(info.start.Add(info.elapsed).Sub(r.series.start) / r.series.interval)
+	if interval != result.seriesInterval {
+		r.flushSeries(result)
+		result.seriesInterval = interval
+	}
+	if result.series == nil {
+		result.series = make(map[string]*seriesBucket)
+	}
+	bucket, ok := result.series[key]
+	if !ok {
+		bucket = &seriesBucket{hist: NewHistogram()}
+		result.series[key] = bucket
+	}
+	bucket.add(info.outcome, info.elapsed)
+}
+
+// Move the buckets of a client to the time series, with result.mu held
+func (r *Runner) flushSeries(result *Result) {
+	if len(result.series) == 0 {
+		return
+	}
+	r.series.Lock()
+	for url, bucket := range result.series {
+		r.mergeSeriesBucket(seriesKey{interval: result.seriesInterval, url: url}, bucket)
+	}
+	r.series.Unlock()
+	result.series = nil
+}
+
+// With r.series locked
+func (r *Runner) mergeSeriesBucket(key seriesKey, bucket *seriesBucket) {
+	merged, ok := r.series.buckets[key]
+	if !ok {
+		merged = &seriesBucket{hist: NewHistogram()}
+		r.series.buckets[key] = merged
+	}
+	merged.merge(bucket)
+}
+
+// Points of the time series so far, ordered by time with the whole run
+// (URL "*") before the URLs of each interval
+func (r *Runner) seriesPoints(percentiles []float64) []SeriesPoint {
+	for _, result := range r.results {
+		result.mu.Lock()
+		r.flushSeries(result)
+		result.mu.Unlock()
+	}
+
+	series := &r.series
+	series.Lock()
+	defer series.Unlock()
+	all := make(map[int64]*seriesBucket)
+	keys := make([]seriesKey, 0, len(series.buckets))
+	for key, bucket := range series.buckets {
+		keys = append(keys, key)
+		total, ok := all[key.interval]
+		if !ok {
+			total = &seriesBucket{hist: NewHistogram()}
+			all[key.interval] = total
+			keys = append(keys, seriesKey{interval: key.interval, url: "*"})
+		}
+		total.merge(bucket)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].interval != keys[j].interval {
+			return keys[i].interval < keys[j].interval
+		}
+		return keys[i].url < keys[j].url
+	})
+
+	points := make([]SeriesPoint, 0, len(keys))
+	for _, key := range keys {
+		bucket := series.buckets[key]
+		if key.url == "*" {
+			bucket = all[key.interval]
+		}
+		start := series.start.Add(time.Duration(key.interval) * series.interval)
+		point := SeriesPoint{Time: start, Offset: start.Sub(series.start).Seconds(),
+			Window: windowNames[r.window.of(start)], URL: key.url,
+			Requests: bucket.requests, Success: bucket.success, NetworkFailed: bucket.networkFailed,
+			BadFailed: bucket.badFailed, Mismatched: bucket.mismatched,
+			Rate: float64(bucket.requests) / series.interval.Seconds(),
+			Mean: bucket.hist.Mean(), Max: bucket.hist.Max()}
+		for _, pct := range percentiles {
+			point.Percentiles = append(point.Percentiles,
+				Percentile{Percentile: pct, Value: bucket.hist.ValueAtPercentile(pct)})
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// Write a time series in the csv or json format, with the percentiles of
+// its points
+func WriteSeries(w io.Writer, format string, points []SeriesPoint, percentiles []float64) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(points)
+	}
+	return writeSeriesCSV(w, points, percentiles)
+}
+
+func writeSeriesCSV(w io.Writer, points []SeriesPoint, percentiles []float64) error {
+	csvWriter := csv.NewWriter(w)
+	header := []string{"TIMESTAMP", "OFFSET(S)", "WINDOW", "URL", "REQUESTS", "SUCC_REQS", "NET_FAILED",
+		"BAD_REQS", "RESP_MISMATCH", "RATE(REQ/S)", "MEAN(US)", "MAX(US)"}
+	for _, pct := range percentiles {
+		header = append(header, "P"+strconv.FormatFloat(pct, 'f', -1, 64)+"(US)")
+	}
+	csvWriter.Write(header)
+
+	for _, point := range points {
+		row := []string{strconv.FormatInt(point.Time.Unix(), 10),
+			strconv.FormatFloat(point.Offset, 'f', -1, 64), point.Window, point.URL,
+			strconv.FormatInt(point.Requests, 10),
+			strconv.FormatInt(point.Success, 10),
+			strconv.FormatInt(point.NetworkFailed, 10),
+			strconv.FormatInt(point.BadFailed, 10),
+			strconv.FormatInt(point.Mismatched, 10),
+			fmt.Sprintf("%.2f", point.Rate),
+			fmt.Sprintf("%.1f", point.Mean),
+			strconv.FormatInt(point.Max, 10)}
+		for _, pct := range point.Percentiles {
+			row = append(row, strconv.FormatInt(pct.Value, 10))
+		}
+		csvWriter.Write(row)
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
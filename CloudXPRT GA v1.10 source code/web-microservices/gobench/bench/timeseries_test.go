@@ -0,0 +1,61 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeriesPoints(t *testing.T) {
+	start := time.Now()
+	results := map[int]*Result{0: {}, 1: {}}
+	r := &Runner{configuration: &Configuration{seriesInterval: time.Second}, results: results}
+	r.series.buckets = make(map[seriesKey]*seriesBucket)
+	r.startSeries(start)
+
+	record := func(client int, url string, at time.Duration, outcome int) {
+		info := &requestInfo{start: start.Add(at - time.Millisecond), elapsed: time.Millisecond, outcome: outcome}
+		results[client].mu.Lock()
+		r.recordSeries(results[client], url, info)
+		results[client].mu.Unlock()
+	}
+	record(0, "a", 100*time.Millisecond, outcomeSuccess)
+	record(1, "a", 200*time.Millisecond, outcomeBadFailed)
+	record(0, "b", 1500*time.Millisecond, outcomeSuccess)
+	record(0, "a", 2500*time.Millisecond, outcomeNetworkFailed)
+
+	points := r.seriesPoints([]float64{50})
+	expected := []struct {
+		offset   float64
+		url      string
+		requests int64
+		success  int64
+	}{{0, "*", 2, 1}, {0, "a", 2, 1}, {1, "*", 1, 1}, {1, "b", 1, 1}, {2, "*", 1, 0}, {2, "a", 1, 0}}
+	if len(points) != len(expected) {
+		t.Fatalf("Expected %d points, got %+v", len(expected), points)
+	}
+	for i, point := range points {
+		if point.Offset != expected[i].offset || point.URL != expected[i].url ||
+			point.Requests != expected[i].requests || point.Success != expected[i].success {
+			t.Fatalf("Wrong point %d: %+v", i, point)
+		}
+	}
+	if points[0].Percentiles[0].Value != 1000 || points[4].NetworkFailed != 1 {
+		t.Fatalf("Wrong latency or failures %+v %+v", points[0], points[4])
+	}
+}
@@ -0,0 +1,98 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// TLS options of the clients. Server certificates are only verified with
+// Verify or CACert, as gobench mostly runs against self-signed test
+// servers. With TLSResume every client keeps a session cache of its own, so
+// its new connections resume the sessions of its earlier ones.
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	caCertFile, certFile, keyFile := cfg.CACert, cfg.Cert, cfg.Key
+	tlsMinVersion, tlsMaxVersion, tlsCiphers := cfg.MinVersion, cfg.MaxVersion, cfg.Ciphers
+	config := &tls.Config{InsecureSkipVerify: !cfg.Verify && caCertFile == "", ServerName: cfg.ServerName}
+
+	if caCertFile != "" {
+		data, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", caCertFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var ok bool
+	if tlsMinVersion != "" {
+		if config.MinVersion, ok = tlsVersions[tlsMinVersion]; !ok {
+			return nil, fmt.Errorf("unknown TLS version %s", tlsMinVersion)
+		}
+	}
+	if tlsMaxVersion != "" {
+		if config.MaxVersion, ok = tlsVersions[tlsMaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown TLS version %s", tlsMaxVersion)
+		}
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return nil, fmt.Errorf("minimum TLS version %s is above the maximum %s", tlsMinVersion, tlsMaxVersion)
+	}
+
+	// TLS 1.3 suites are not configurable, they only apply up to TLS 1.2
+	if tlsCiphers != "" {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range strings.Split(tlsCiphers, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %s", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	return config, nil
+}
+
+// Name of a negotiated TLS version, like 1.3
+func tlsVersionName(version uint16) string {
+	for name, id := range tlsVersions {
+		if id == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,284 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Phases of a request, new connections add dial and TLS handshake
+const (
+	phaseDial = iota
+	phaseTLS
+	phaseTTFB
+	phaseBody
+	phaseCount
+)
+
+var phaseNames = []string{"dial", "tls", "ttfb", "body"}
+
+// Timestamps of the request a client has in flight. A client only sends one
+// request at a time and fasthttp dials and does I/O on the calling goroutine,
+// so the connection can write them without locking.
+type phaseTrace struct {
+	timing    bool
+	dialed    bool
+	dial      time.Duration
+	handshake time.Duration
+	resumed   bool
+	// TLS version and cipher suite of the new connection
+	negotiated string
+	written    time.Time
+	firstByte  time.Time
+}
+
+type MyConn struct {
+	net.Conn
+	owner  *connOwner
+	trace  *phaseTrace
+	closed int32
+}
+
+// Runner a connection counts its bytes and its end for, the next run when
+// a ConnCache hands the client of the connection over
+type connOwner struct {
+	run atomic.Value
+}
+
+func newConnOwner(run *Runner) *connOwner {
+	owner := &connOwner{}
+	owner.run.Store(run)
+	return owner
+}
+
+func (owner *connOwner) runner() *Runner {
+	return owner.run.Load().(*Runner)
+}
+
+func (mc *MyConn) Read(b []byte) (n int, err error) {
+	len, err := mc.Conn.Read(b)
+
+	if run := mc.owner.runner(); err == nil && run.window.of(time.Now()) == windowMeasured {
+		atomic.AddInt64(&run.readBytes, int64(len))
+	}
+
+	if trace := mc.trace; trace != nil && trace.timing && len > 0 && trace.firstByte.IsZero() {
+		trace.firstByte = time.Now()
+	}
+
+	return len, err
+}
+
+func (mc *MyConn) Write(b []byte) (n int, err error) {
+	len, err := mc.Conn.Write(b)
+
+	if run := mc.owner.runner(); err == nil && run.window.of(time.Now()) == windowMeasured {
+		atomic.AddInt64(&run.writeBytes, int64(len))
+	}
+
+	if trace := mc.trace; trace != nil && trace.timing {
+		trace.written = time.Now()
+		trace.firstByte = time.Time{}
+	}
+
+	return len, err
+}
+
+func (mc *MyConn) Close() error {
+	if atomic.CompareAndSwapInt32(&mc.closed, 0, 1) {
+		run := mc.owner.runner()
+		run.countConn(&run.connsClosed)
+	}
+	return mc.Conn.Close()
+}
+
+// Dial a TCP connection and do the TLS handshake here rather than in
+// fasthttp, so both can be timed. tlsConfig is nil for plain http.
+func MyDialer(run *Runner, trace *phaseTrace, tlsConfig *tls.Config, timeout time.Duration) fasthttp.DialFunc {
+	return ownedDialer(newConnOwner(run), trace, tlsConfig, timeout)
+}
+
+// MyDialer for the connections of a client, which may move on to the next
+// run with it
+func ownedDialer(owner *connOwner, trace *phaseTrace, tlsConfig *tls.Config, timeout time.Duration) fasthttp.DialFunc {
+	return func(address string) (net.Conn, error) {
+		run := owner.runner()
+		start := time.Now()
+		conn, err := run.configuration.dial(address, tlsConfig != nil, 0)
+		if err != nil {
+			run.countConn(&run.connErrors)
+			return nil, err
+		}
+
+		run.countConn(&run.connsOpened)
+		myConn := &MyConn{Conn: conn, owner: owner, trace: trace}
+		trace.dialed = true
+		trace.dial = time.Since(start)
+
+		if tlsConfig == nil {
+			return myConn, nil
+		}
+
+		start = time.Now()
+		tlsConn := tls.Client(myConn, tlsConfig)
+		if timeout > 0 {
+			tlsConn.SetDeadline(start.Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			run.countConn(&run.connErrors)
+			myConn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		trace.handshake = time.Since(start)
+		state := tlsConn.ConnectionState()
+		trace.resumed = state.DidResume
+		trace.negotiated = tlsVersionName(state.Version) + " " + tls.CipherSuiteName(state.CipherSuite)
+
+		return tlsConn, nil
+	}
+}
+
+// HTTP client of one virtual client, with one fasthttp.HostClient per
+// scheme and host like fasthttp.Client but dialing through MyDialer
+type httpClient struct {
+	ctx           context.Context
+	run           *Runner
+	configuration *Configuration
+	trace         phaseTrace
+	hosts         map[string]*fasthttp.HostClient
+	sessions      tls.ClientSessionCache
+	owner         *connOwner
+	// shared transport of h2 and h2c
+	h2 int
+	// endpoint of the request just done, empty without load balancing
+	endpoint string
+}
+
+func newHTTPClient(ctx context.Context, run *Runner) *httpClient {
+	configuration := run.configuration
+	c := &httpClient{
+		ctx:           ctx,
+		run:           run,
+		configuration: configuration,
+		trace:         phaseTrace{timing: configuration.phases},
+		hosts:         make(map[string]*fasthttp.HostClient),
+		owner:         newConnOwner(run)}
+	if configuration.tlsResume {
+		c.sessions = tls.NewLRUClientSessionCache(0)
+	}
+	if configuration.protocol != protoHTTP1 {
+		c.h2 = int(atomic.AddInt64(&run.h2.next, 1)-1) % len(run.h2.transports)
+	}
+	return c
+}
+
+func (c *httpClient) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	c.trace.dialed = false
+	c.trace.resumed = false
+	c.trace.negotiated = ""
+	c.trace.written = time.Time{}
+	c.trace.firstByte = time.Time{}
+	var target *endpoint
+	if c.run.balancer != nil {
+		target = c.run.balancer.pick()
+		c.endpoint = target.addr
+		atomic.AddInt64(&target.outstanding, 1)
+		defer atomic.AddInt64(&target.outstanding, -1)
+	}
+	if c.configuration.protocol != protoHTTP1 {
+		return c.doH2(req, resp, target)
+	}
+
+	uri := req.URI()
+	isTLS := false
+	if bytes.Equal(uri.Scheme(), []byte("https")) {
+		isTLS = true
+	} else if !bytes.Equal(uri.Scheme(), []byte("http")) {
+		return fmt.Errorf("unsupported protocol %q. http and https are supported", uri.Scheme())
+	}
+
+	key := string(uri.Scheme()) + "://" + string(uri.Host())
+	if target != nil {
+		key += "@" + target.addr
+	}
+	hostClient, ok := c.hosts[key]
+	if !ok {
+		addr := addMissingPort(string(uri.Host()), isTLS)
+		dialAddr := addr
+		if target != nil {
+			dialAddr = target.addr
+		}
+		var tlsConfig *tls.Config
+		if isTLS {
+			tlsConfig = c.configuration.tlsConfig.Clone()
+			tlsConfig.ClientSessionCache = c.sessions
+			if len(tlsConfig.ServerName) == 0 {
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+		}
+		hostClient = &fasthttp.HostClient{
+			Addr:         dialAddr,
+			IsTLS:        isTLS,
+			ReadTimeout:  c.configuration.readTimeout,
+			WriteTimeout: c.configuration.writeTimeout,
+			Dial:         ownedDialer(c.owner, &c.trace, tlsConfig, c.configuration.writeTimeout)}
+		c.hosts[key] = hostClient
+	}
+
+	return hostClient.Do(req, resp)
+}
+
+// Phases of the request just done, false for phases that did not happen
+func (c *httpClient) phases() ([phaseCount]time.Duration, [phaseCount]bool) {
+	var durations [phaseCount]time.Duration
+	var valid [phaseCount]bool
+	trace := &c.trace
+
+	if trace.dialed {
+		durations[phaseDial], valid[phaseDial] = trace.dial, true
+		if trace.handshake > 0 {
+			durations[phaseTLS], valid[phaseTLS] = trace.handshake, true
+		}
+	}
+	if !trace.written.IsZero() && !trace.firstByte.IsZero() {
+		durations[phaseTTFB], valid[phaseTTFB] = trace.firstByte.Sub(trace.written), true
+		durations[phaseBody], valid[phaseBody] = time.Since(trace.firstByte), true
+	}
+	trace.handshake = 0
+	return durations, valid
+}
+
+func addMissingPort(addr string, isTLS bool) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	port := "80"
+	if isTLS {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
@@ -0,0 +1,306 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Validation rules check every response on top of the expected pattern.
+// They are separated by ";", for example
+//
+//	status=2xx|404;header:Content-Type~json;size<=5000;body~Monte.*Carlo
+//	json:results#>=5;json:results.*.callresult>0
+//
+// A rule is a subject, an operator and a value. Subjects are status, size
+// (of the body in bytes), body, header:name, json:path (see jsonPath, * takes
+// all elements of an array or object) and json:path# (number of elements of
+// the value, or of the matches of *). Operators are = and != with values
+// separated by | which are exact values, ranges like 10-500 or status
+// classes like 2xx, ~ and !~ with a regular expression, and <, <=, > and >=
+// with a number. A header or json subject without an operator only has to
+// exist. A missing header or JSON value fails every rule, and json:path*
+// rules hold for all values.
+//
+// Rules of a URL ([CHECK] at the end of its line) or of a scenario step
+// ([CHECK] lines) replace the rules of all URLs (Config.Check). With a status
+// rule any status it accepts is a success, without one only 200 is. The
+// first failed rule of a response counts as a mismatch of kind check.
+type check struct {
+	text    string
+	subject string
+	arg     string
+	count   bool
+	op      string
+	values  []string
+	re      *regexp.Regexp
+	limit   float64
+}
+
+// Longer operators first, so <= is not taken for <
+var checkOps = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+func parseChecks(spec string) ([]*check, error) {
+	var checks []*check
+	for _, text := range strings.Split(spec, ";") {
+		text = strings.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		c, err := parseCheck(text)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, c)
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no validation rule in %q", spec)
+	}
+	return checks, nil
+}
+
+func parseCheck(text string) (*check, error) {
+	c := &check{text: text}
+	rest := text
+	for _, subject := range []string{"status", "size", "body", "header:", "json:"} {
+		if strings.HasPrefix(rest, subject) {
+			c.subject = strings.TrimSuffix(subject, ":")
+			rest = rest[len(subject):]
+			break
+		}
+	}
+	if c.subject == "" {
+		return nil, fmt.Errorf("unknown subject in validation rule %q", text)
+	}
+
+	end := strings.IndexAny(rest, "=!~<>")
+	if end < 0 {
+		end = len(rest)
+	}
+	c.arg, rest = rest[:end], rest[end:]
+	if c.subject == "json" && strings.HasSuffix(c.arg, "#") {
+		c.count = true
+		c.arg = strings.TrimSuffix(c.arg, "#")
+	}
+	if (c.subject == "header" || c.subject == "json") != (len(c.arg) > 0) {
+		return nil, fmt.Errorf("invalid subject in validation rule %q", text)
+	}
+	for _, op := range checkOps {
+		if strings.HasPrefix(rest, op) {
+			c.op = op
+			rest = rest[len(op):]
+			break
+		}
+	}
+
+	numeric := c.subject == "status" || c.subject == "size" || c.count
+	switch {
+	case c.op == "" && len(rest) > 0:
+		return nil, fmt.Errorf("invalid operator in validation rule %q", text)
+	case c.op == "" && (numeric || c.subject == "body"):
+		return nil, fmt.Errorf("validation rule %q needs an operator", text)
+	case c.subject == "body" && c.op != "~" && c.op != "!~":
+		return nil, fmt.Errorf("body rule %q takes ~ or !~", text)
+	case numeric && (c.op == "~" || c.op == "!~"):
+		return nil, fmt.Errorf("numeric rule %q takes no regular expression", text)
+	}
+
+	switch c.op {
+	case "=", "!=":
+		c.values = strings.Split(rest, "|")
+		for _, value := range c.values {
+			if len(value) == 0 {
+				return nil, fmt.Errorf("empty value in validation rule %q", text)
+			}
+		}
+	case "~", "!~":
+		re, err := regexp.Compile(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in validation rule %q", text)
+		}
+		c.re = re
+	case "<", "<=", ">", ">=":
+		limit, err := strconv.ParseFloat(rest, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in validation rule %q", text)
+		}
+		c.limit = limit
+	}
+	return c, nil
+}
+
+// Whether any rule decides on the status code
+func checksStatus(checks []*check) bool {
+	for _, c := range checks {
+		if c.subject == "status" {
+			return true
+		}
+	}
+	return false
+}
+
+// A response under validation, its body is decoded as JSON only once
+type validation struct {
+	resp    *fasthttp.Response
+	doc     interface{}
+	decoded bool
+	valid   bool
+}
+
+// The first rule resp does not pass, nil when it passes all
+func validate(checks []*check, resp *fasthttp.Response) *check {
+	v := &validation{resp: resp}
+	for _, c := range checks {
+		if !c.passes(v) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (v *validation) json() (interface{}, bool) {
+	if !v.decoded {
+		v.decoded = true
+		v.doc, v.valid = decodeJSON(v.resp.Body())
+	}
+	return v.doc, v.valid
+}
+
+func (c *check) passes(v *validation) bool {
+	var values []string
+	switch c.subject {
+	case "status":
+		values = []string{strconv.Itoa(v.resp.StatusCode())}
+	case "size":
+		values = []string{strconv.Itoa(len(v.resp.Body()))}
+	case "body":
+		return c.re.Match(v.resp.Body()) == (c.op == "~")
+	case "header":
+		value := v.resp.Header.Peek(c.arg)
+		if value == nil {
+			return false
+		}
+		values = []string{string(value)}
+	default:
+		doc, ok := v.json()
+		if !ok {
+			return false
+		}
+		nodes := jsonLookup(doc, jsonKeys(c.arg))
+		if c.count {
+			count := len(nodes)
+			if len(nodes) == 1 && !strings.Contains(c.arg, "*") {
+				switch node := nodes[0].(type) {
+				case []interface{}:
+					count = len(node)
+				case map[string]interface{}:
+					count = len(node)
+				}
+			}
+			values = []string{strconv.Itoa(count)}
+			break
+		}
+		for _, node := range nodes {
+			values = append(values, jsonString(node))
+		}
+	}
+
+	if len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		if !c.holds(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *check) holds(value string) bool {
+	switch c.op {
+	case "":
+		return true
+	case "=", "!=":
+		matched := false
+		for _, want := range c.values {
+			if matchValue(value, want) {
+				matched = true
+				break
+			}
+		}
+		return matched == (c.op == "=")
+	case "~", "!~":
+		return c.re.MatchString(value) == (c.op == "~")
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return number < c.limit
+	case "<=":
+		return number <= c.limit
+	case ">":
+		return number > c.limit
+	default:
+		return number >= c.limit
+	}
+}
+
+// An exact value, a range like 10-500 or a status class like 2xx
+func matchValue(value string, want string) bool {
+	if value == want {
+		return true
+	}
+	if len(want) == 3 && strings.HasSuffix(want, "xx") {
+		return len(value) == 3 && value[0] == want[0]
+	}
+	// the lower bound may be negative
+	if idx := strings.Index(want, "-"); len(want) > 1 && strings.Contains(want[1:], "-") {
+		if idx == 0 {
+			idx = strings.Index(want[1:], "-") + 1
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		lower, errLower := strconv.ParseFloat(want[:idx], 64)
+		upper, errUpper := strconv.ParseFloat(want[idx+1:], 64)
+		return err == nil && errLower == nil && errUpper == nil && number >= lower && number <= upper
+	}
+	return false
+}
+
+// Text of a JSON value, strings without quotes
+func jsonString(node interface{}) string {
+	switch value := node.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case nil:
+		return "null"
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
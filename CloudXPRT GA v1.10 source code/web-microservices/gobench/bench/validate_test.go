@@ -0,0 +1,62 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestValidate(t *testing.T) {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	resp.SetStatusCode(201)
+	resp.Header.Set("Content-Type", "application/json")
+	resp.SetBodyString(`{"name":"mc","results":[{"callresult":3.5},{"callresult":1}],"took":-2}`)
+
+	passing := []string{"status=2xx", "status=404|200-204", "status!=500", "header:Content-Type",
+		"header:Content-Type~json", "size>=20", "size=10-200", "body~results.*callresult",
+		"body!~error", "json:name=mc", "json:$.results[1].callresult=1", "json:results#=2",
+		"json:results.*.callresult>0", "json:took=-5--1", "json:missing#=0", "json:*#=3"}
+	for _, text := range passing {
+		checks, err := parseChecks(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed := validate(checks, resp); failed != nil {
+			t.Fatalf("%s should pass", text)
+		}
+	}
+
+	failing := []string{"status=200", "status=5xx", "header:Server", "header:Content-Type=text/html",
+		"size<10", "body~^\\[", "json:name!=mc", "json:results.*.callresult>2", "json:missing",
+		"json:results#>2"}
+	for _, text := range failing {
+		checks, _ := parseChecks("status=201;" + text)
+		if failed := validate(checks, resp); failed == nil || failed.text != text {
+			t.Fatalf("%s should fail", text)
+		}
+	}
+
+	for _, invalid := range []string{"", "latency<5", "status", "status~2", "size=", "body=foo",
+		"header:=x", "json:a~(", "size>big", "json:a=1|"} {
+		if _, err := parseChecks(invalid); err == nil {
+			t.Fatalf("%q should be invalid", invalid)
+		}
+	}
+}
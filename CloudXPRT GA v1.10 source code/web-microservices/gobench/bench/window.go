@@ -0,0 +1,111 @@
+/*******************************************************************************
+* Copyright 2020 BenchmarkXPRT Development Community
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package bench
+
+import (
+	"math"
+	"time"
+)
+
+// Windows of a run, by the time a request is started
+const (
+	windowWarmup = iota
+	windowCooldown
+	windowMeasured
+)
+
+// Measurement window of a run. Requests started before it (warm-up) or after
+// it (cool-down) are left out of all statistics and only counted by window.
+// A zero time leaves that side of the window open.
+type measureWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// Requests of the warm-up or cool-down window
+type excludedResult struct {
+	requests      int64
+	success       int64
+	networkFailed int64
+	badFailed     int64
+	mismatched    int64
+}
+
+// Set the window of the run before the clients start
+func (r *Runner) setWindow(start time.Time) {
+	configuration := r.configuration
+	r.window = measureWindow{}
+	if configuration.warmup > 0 {
+		r.window.start = start.Add(configuration.warmup)
+	}
+	if configuration.cooldown > 0 {
+		r.window.end = start.Add(configuration.period - configuration.cooldown)
+	}
+}
+
+func (w *measureWindow) of(t time.Time) int {
+	if !w.start.IsZero() && t.Before(w.start) {
+		return windowWarmup
+	}
+	if !w.end.IsZero() && !t.Before(w.end) {
+		return windowCooldown
+	}
+	return windowMeasured
+}
+
+// Time measured so far of a run started at startTime
+func (w *measureWindow) elapsed(startTime time.Time) time.Duration {
+	from, to := startTime, time.Now()
+	if w.start.After(from) {
+		from = w.start
+	}
+	if !w.end.IsZero() && w.end.Before(to) {
+		to = w.end
+	}
+	if to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+func (e *excludedResult) add(outcome int) {
+	e.requests++
+	switch outcome {
+	case outcomeSuccess:
+		e.success++
+	case outcomeNetworkFailed:
+		e.networkFailed++
+	case outcomeBadFailed:
+		e.badFailed++
+	case outcomeMismatched:
+		e.mismatched++
+	}
+}
+
+// Offset of a scheduled request from the start of an open-loop run when the
+// arrival rate ramps up linearly over ramp. offset is the offset at the full
+// rate, the ramp stretches it so the first requests come slower.
+func rampOffset(offset time.Duration, ramp time.Duration) time.Duration {
+	if ramp <= 0 {
+		return offset
+	}
+	// the requests of the ramp would take half of it at the full rate
+	if offset < ramp/2 {
+		return time.Duration(math.Sqrt(2 * float64(ramp) * float64(offset)))
+	}
+	return offset + ramp/2
+}
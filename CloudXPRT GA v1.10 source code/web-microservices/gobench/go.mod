@@ -4,5 +4,6 @@ go 1.14
 
 require (
     github.com/valyala/fasthttp v1.14.0
+    golang.org/x/net v0.0.0-20200707034311-ab3426394381
 )
 
@@ -25,27 +25,21 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/tls"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
-	"net"
 	"os"
 	"os/signal"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/valyala/fasthttp"
+	"gobench/bench"
 )
 
 var (
@@ -61,63 +55,66 @@ var (
 	authHeader       string
 	cookieHeader     string
 	expResult        string
+	arrivalRate      float64
+	arrivalMode      string
+	percentiles      string
+	histFilePath     string
+	outputFormat     string
+	outputFilePath   string
+	scenarioFilePath string
+	phases           bool
+	metricsAddr      string
+	agentListenAddr  string
+	agentAddrs       string
+	agentToken       string
+	spawnAgents      int
+	rampUp           int
+	warmup           int
+	cooldown         int
+	replayFilePath   string
+	replayTarget     string
+	replaySpeed      float64
+	thinkSpec        string
+	pace             int
+	seed             int64
+	tlsVerify        bool
+	caCertFile       string
+	certFile         string
+	keyFile          string
+	tlsServerName    string
+	tlsMinVersion    string
+	tlsMaxVersion    string
+	tlsCiphers       string
+	tlsResume        bool
+	protocol         string
+	h2ConnCount      int
+	seriesFilePath   string
+	seriesInterval   int
+	seriesFormat     string
+	slaSpec          string
+	checkSpec        string
+	connMode         string
+	poolSize         int
+	connChurn        float64
+	endpoints        string
+	proxyURL         string
+	noProxy          string
+	unixSocket       string
+	balance          string
+	usersFilePath    string
+	loginLine        string
+	loginExtract     string
+	relogin          int
+	cookieJar        bool
+	firstClient      int
 )
 
-type Configuration struct {
-	urls         []string
-	method       string
-	postData     []byte
-	requests     int64
-	period       int64
-	keepAlive    bool
-	authHeader   string
-	cookieHeader string
-
-	myClient fasthttp.Client
-}
-
-type Result struct {
-	requests      int64
-	success       int64
-	networkFailed int64
-	badFailed     int64
-	mismatched    int64
-}
-
-var readThroughput int64
-var writeThroughput int64
-
-var DEBUG = false
+// Progress and error messages, kept off stdout when a report is written there
+var progress io.Writer = os.Stdout
 var timeThreshold = "-1"
 
-var respTimeMap = make(map[string][]int)
-var timeThresholdMap = make(map[string]string)
-
-var m sync.RWMutex
-
-type MyConn struct {
-	net.Conn
-}
-
-func (mc *MyConn) Read(b []byte) (n int, err error) {
-	len, err := mc.Conn.Read(b)
-
-	if err == nil {
-		atomic.AddInt64(&readThroughput, int64(len))
-	}
-
-	return len, err
-}
-
-func (mc *MyConn) Write(b []byte) (n int, err error) {
-	len, err := mc.Conn.Write(b)
-
-	if err == nil {
-		atomic.AddInt64(&writeThroughput, int64(len))
-	}
-
-	return len, err
-}
+// Exit status of a run that violated its SLA rules
+const exitSLAViolated = 3
 
 func init() {
 	flag.Int64Var(&requests, "r", -1, "Number of requests per client")
@@ -125,412 +122,283 @@ func init() {
 	flag.StringVar(&url, "u", "", "URL")
 	flag.StringVar(&urlsFilePath, "f", "", "URL's file path (line seperated)")
 	flag.BoolVar(&keepAlive, "k", true, "Do HTTP keep-alive")
+	flag.StringVar(&connMode, "conn", "", "Connection mode (client|pool|request): one connection per client, a shared pool, one per request")
+	flag.IntVar(&poolSize, "pool", 0, "Number of connections per host the clients share in pool mode")
+	flag.Float64Var(&connChurn, "churn", 0, "Close this number of keep-alive connections per second after their response")
+	flag.StringVar(&endpoints, "endpoints", "", "Send the requests of all URLs to these comma separated host:port or dns:name:port endpoints")
+	flag.StringVar(&balance, "lb", "rr", "Load balancing policy across the endpoints (rr|random|least)")
+	flag.StringVar(&proxyURL, "proxy", "", "Connect through this http://, https:// or socks5:// proxy, \"direct\" ignores http_proxy and https_proxy")
+	flag.StringVar(&noProxy, "noproxy", "", "Comma separated hosts and domains not to reach through the proxy")
+	flag.StringVar(&unixSocket, "unix", "", "Connect to this Unix domain socket instead of the URL host")
 	flag.StringVar(&postDataFilePath, "d", "", "HTTP POST data file path")
 	flag.Int64Var(&period, "t", -1, "Period of time (in seconds)")
 	flag.IntVar(&writeTimeout, "tw", 5000, "Write timeout (in milliseconds)")
 	flag.IntVar(&readTimeout, "tr", 5000, "Read timeout (in milliseconds)")
 	flag.StringVar(&authHeader, "auth", "", "Authorization header")
 	flag.StringVar(&cookieHeader, "cookie", "", "Cookie header")
+	flag.BoolVar(&cookieJar, "jar", false, "Keep the cookies set by the responses to each client and send them back")
+	flag.StringVar(&usersFilePath, "users", "", "User pool CSV file, client i takes row i and ${user.column} its values")
+	flag.StringVar(&loginLine, "login", "", "Login request of every client as URL or URL[POST]body, sent again after a 401")
+	flag.StringVar(&loginExtract, "token", "", "Value of the login response to keep as ${user.variable}, for example token=json:token")
+	flag.IntVar(&relogin, "relogin", 0, "Log every client in again after this period (in seconds)")
+	flag.IntVar(&firstClient, "first", 0, "Number of the first client, for ${client} and the user pool")
 	flag.StringVar(&expResult, "e", "", "Expected string pattern from response")
-	// flag.StringVar(&timeThreshold, "tt", "-1", "Time threshold for Apdex score (in milliseconds)")
+	flag.StringVar(&checkSpec, "check", "", "Validation rules of all responses separated by ;, for example status=2xx;json:results#>=1")
+	flag.Float64Var(&arrivalRate, "rate", 0, "Open-loop mode: target request rate of all clients (requests/sec)")
+	flag.StringVar(&arrivalMode, "arrival", "fixed", "Open-loop arrival distribution (fixed|poisson)")
+	flag.StringVar(&percentiles, "pct", "50,60,70,80,90,95,99,99.9,100", "Response time percentiles to report")
+	flag.StringVar(&histFilePath, "hout", "", "Write response time histograms by URL to this file (JSON)")
+	flag.StringVar(&outputFormat, "fmt", "text", "Report format (text|json|csv)")
+	flag.StringVar(&outputFilePath, "o", "", "Write the report to this file instead of stdout")
+	flag.StringVar(&scenarioFilePath, "sc", "", "Scenario file path, every client runs its steps in order")
+	flag.BoolVar(&phases, "phases", false, "Time request phases (dial, TLS handshake, time to first byte, body)")
+	flag.StringVar(&metricsAddr, "metrics", "", "Serve live Prometheus metrics on this address during the run, for example :9100")
+	flag.StringVar(&agentListenAddr, "agent", "", "Run as an agent of a coordinator, listening on this address of a private network, for example 10.0.0.5:7070")
+	flag.StringVar(&agentAddrs, "agents", "", "Coordinate the agents at these comma separated addresses instead of running clients")
+	flag.StringVar(&agentToken, "agenttoken", "", "Shared token of the agents and their coordinator, required by -agent and -agents")
+	flag.IntVar(&spawnAgents, "spawn", 0, "Coordinate this number of agent processes started on this machine")
+	flag.IntVar(&rampUp, "ramp", 0, "Start the clients, or ramp up the open-loop rate, over this period (in seconds)")
+	flag.IntVar(&warmup, "warmup", 0, "Leave requests started in this first period out of the statistics (in seconds)")
+	flag.IntVar(&cooldown, "cooldown", 0, "Leave requests started in this last period of a timed run out of the statistics (in seconds)")
+	flag.StringVar(&replayFilePath, "replay", "", "Replay the requests of an access log or HAR file at their recorded times")
+	flag.StringVar(&replayTarget, "target", "", "Send replayed requests to this scheme://host:port instead of the recorded host")
+	flag.Float64Var(&replaySpeed, "speed", 1, "Replay speed, 2 replays the recorded traffic twice as fast")
+	flag.StringVar(&thinkSpec, "think", "", "Think time after each request (ms), uniform:min-max or exp:mean")
+	flag.IntVar(&pace, "pace", 0, "Start each request, or scenario session, of a client at most every pace ms")
+	flag.Int64Var(&seed, "seed", 1, "Seed of the random values of templates and think times, client i uses seed+i")
+	flag.BoolVar(&tlsVerify, "verify", false, "Verify the certificates of https servers against the system roots")
+	flag.StringVar(&caCertFile, "cacert", "", "Verify the certificates of https servers against this CA bundle (PEM)")
+	flag.StringVar(&certFile, "cert", "", "Client certificate file (PEM) for mutual TLS")
+	flag.StringVar(&keyFile, "key", "", "Client private key file (PEM) for mutual TLS")
+	flag.StringVar(&tlsServerName, "sni", "", "Server name sent in the TLS handshake and verified, instead of the URL host")
+	flag.StringVar(&tlsMinVersion, "tlsmin", "", "Minimum TLS version (1.0|1.1|1.2|1.3)")
+	flag.StringVar(&tlsMaxVersion, "tlsmax", "", "Maximum TLS version (1.0|1.1|1.2|1.3)")
+	flag.StringVar(&tlsCiphers, "ciphers", "", "Comma separated TLS 1.0-1.2 cipher suites, for example TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	flag.BoolVar(&tlsResume, "resume", false, "Resume TLS sessions on the new connections of a client")
+	flag.StringVar(&protocol, "proto", "http1", "Protocol (http1|h2|h2c), h2 for https and h2c for http URLs")
+	flag.IntVar(&h2ConnCount, "h2conns", 1, "Number of HTTP/2 transports the clients share, each with one connection per host")
+	flag.StringVar(&seriesFilePath, "ts", "", "Write a time series of the requests by URL to this file")
+	flag.IntVar(&seriesInterval, "tsint", 1, "Interval of the time series (in seconds)")
+	flag.StringVar(&seriesFormat, "tsfmt", "csv", "Time series format (csv|json)")
+	flag.StringVar(&timeThreshold, "tt", "-1", "Time thresholds for Apdex score of all URLs as lower:upper (in milliseconds)")
+	flag.StringVar(&slaSpec, "sla", "", "SLA rules of all URLs, for example p95<=500,errors<=1%; [SLA] in the URL or scenario file sets them per URL")
 }
 
-func printResults(results map[int]*Result, startTime time.Time) {
-	var requests int64
-	var success int64
-	var networkFailed int64
-	var badFailed int64
-	var mismatched int64
-
-	for _, result := range results {
-		requests += result.requests
-		success += result.success
-		networkFailed += result.networkFailed
-		badFailed += result.badFailed
-		mismatched += result.mismatched
+func printResults(report *bench.Report) {
+	var out io.Writer = os.Stdout
+	if outputFilePath != "" {
+		file, err := os.Create(outputFilePath)
+		if err != nil {
+			log.Fatalf("Error creating output file: %s Error: %s", outputFilePath, err.Error())
+		}
+		defer file.Close()
+		out = file
 	}
-
-	elapsed := int64(time.Since(startTime).Seconds())
-
-	if elapsed == 0 {
-		elapsed = 1
+	if err := bench.WriteReport(out, report, outputFormat); err != nil {
+		log.Printf("Error writing report: %s", err.Error())
 	}
-
-	fmt.Println()
-	fmt.Printf("Requests:                       %10d hits\n", requests)
-	fmt.Printf("Successful requests:            %10d hits\n", success)
-	fmt.Printf("Network failed:                 %10d hits\n", networkFailed)
-	fmt.Printf("Bad requests failed (!2xx):     %10d hits\n", badFailed)
-	fmt.Printf("Pattern mismatch:               %10d hits\n", mismatched)
-	fmt.Printf("Successful requests rate:       %10.2f hits/sec\n", (float32(success))/(float32(elapsed)))
-	fmt.Printf("Read throughput:                %10d bytes/sec\n", readThroughput/elapsed)
-	fmt.Printf("Write throughput:               %10d bytes/sec\n", writeThroughput/elapsed)
-	fmt.Printf("Test time:                      %10d sec\n", elapsed)
-
-	// Time elapsed results
-	fmt.Println("\nPercentage of the requests served within a certain time (ms)")
-	// Need sort respTimeMap by its keys
-	keys := make([]string, len(respTimeMap))
-	var i = 0
-	for key := range respTimeMap {
-		keys[i] = key
-		i++
+	// the text report has the verdict at its end
+	if report.SLA != nil && outputFormat != "text" {
+		bench.WriteSLA(progress, report.SLA)
 	}
-	sort.Strings(keys)
-
-	for _, key := range keys {
-		value := respTimeMap[key]
-		size := len(value)
-		sort.Ints(value)
-		fmt.Println("For URL:", key, "\nTotal", size, "responses are received")
-		fmt.Printf(" 50%% %10d\n", value[(int)(size/2)])
-		fmt.Printf(" 60%% %10d\n", value[(int)(size*6/10)])
-		fmt.Printf(" 70%% %10d\n", value[(int)(size*7/10)])
-		fmt.Printf(" 80%% %10d\n", value[(int)(size*8/10)])
-		fmt.Printf(" 90%% %10d\n", value[(int)(size*9/10)])
-		fmt.Printf(" 95%% %10d\n", value[(int)(size*95/100)])
-		fmt.Printf("100%% %10d\n", value[(int)(size-1)])
-
-		// Mainly for file contains multi URLs case
-		if val, ok := timeThresholdMap[key]; ok {
-			timeThreshold = val
-		}
 
-		// If time threshold is set as lower:upper format, calculate the Apdex score here
-		if strings.Contains(timeThreshold, ":") {
-
-			tlower, tupper := handleTimeThreshold(timeThreshold)
-			var satisfied, tolerated, frustrated = 0, 0, 0
-			for _, v := range value {
-				if v <= tlower {
-					satisfied++
-				} else if v > tlower && v <= tupper {
-					tolerated++
-				} else {
-					frustrated++
-				}
-			}
-			fmt.Printf("\nFor time threshold values [%d:%dms]\n", tlower, tupper)
-			fmt.Printf("Satisfied requests count:  %10d\n", satisfied)
-			fmt.Printf("Tolerated requests count:  %10d\n", tolerated)
-			fmt.Printf("Frustrated requests count: %10d\n", frustrated)
-			fmt.Printf("Apdex score is: %.5f\n\n",
-				(float32(satisfied)+float32(tolerated)/2.0)/(float32(size)))
+	if histFilePath != "" {
+		hists := make(map[string]*bench.Histogram)
+		for _, urlReport := range report.URLs {
+			hists[urlReport.URL] = urlReport.Histogram
+		}
+		data, err := json.Marshal(hists)
+		if err == nil {
+			err = ioutil.WriteFile(histFilePath, data, 0644)
+		}
+		if err != nil {
+			log.Printf("Error writing histogram file: %s Error: %s", histFilePath, err.Error())
 		}
 	}
-}
-
-// In the format of lower:upper
-func handleTimeThreshold(input string) (int, int) {
-	tokens := strings.Split(input, ":")
-	tlower, err := strconv.Atoi(tokens[0])
-	if err != nil {
-		log.Fatalf("Invalid satisfied time threshold %s", tokens[0])
-	}
-	tupper, err := strconv.Atoi(tokens[1])
-	if err != nil {
-		log.Fatalf("Invalid tolerated time threshold %s", tokens[1])
-	}
-	if tlower >= tupper {
-		log.Fatalf("Satisfied time threshold should be smaller than tolerated [%d:%d]",
-			tlower, tupper)
-	}
-	return tlower, tupper
-}
 
-func shuffle(urls []string, size int) {
-	for i := 0; i < size; i++ {
-		temp := rand.Intn(size)
-		urls[i], urls[temp] = urls[temp], urls[i]
+	if seriesFilePath != "" {
+		file, err := os.Create(seriesFilePath)
+		if err == nil {
+			err = bench.WriteSeries(file, seriesFormat, report.Series, report.Config.Percentiles)
+			file.Close()
+		}
+		if err != nil {
+			log.Printf("Error writing time series file: %s Error: %s", seriesFilePath, err.Error())
+		}
 	}
 }
 
-func readLines(path string) (lines []string, err error) {
-
-	var file *os.File
-	var part []byte
-	var prefix bool
-	var currWeight = 0
-
-	if file, err = os.Open(path); err != nil {
-		return
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	buffer := bytes.NewBuffer(make([]byte, 0))
-	for {
-		if part, prefix, err = reader.ReadLine(); err != nil {
-			break
-		}
-		buffer.Write(part)
-		if !prefix {
-			temp := strings.TrimSpace(buffer.String())
-			if strings.HasPrefix(temp, "WEIGHT:") {
-				// Format of this line is: WEIGHT:3
-				tempWeight := strings.TrimPrefix(temp, "WEIGHT:")
-				currWeight, err = strconv.Atoi(tempWeight)
-				if err != nil {
-					return
-				}
-				buffer.Reset()
-			} else {
-				if currWeight > 0 {
-					// Get time threshold value and trim this part
-					if strings.Contains(temp, "[THOLD]") {
-						results := strings.Split(temp, "[THOLD]")
-						idx := strings.Index(temp, "[")
-						timeThresholdMap[temp[0:idx]] = results[1]
-						temp = results[0]
-					}
-					if DEBUG {
-						fmt.Println(temp, " is attached ", currWeight)
-					}
-					for i := 0; i < currWeight; i++ {
-						lines = append(lines, temp)
-					}
-				}
-				buffer.Reset()
-			}
+// In the format of comma separated percentiles, for example 50,95,99.9
+func parsePercentiles(input string) []float64 {
+	var pcts []float64
+	for _, token := range strings.Split(input, ",") {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(token), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			log.Fatalf("Invalid percentile %s", token)
 		}
+		pcts = append(pcts, pct)
 	}
-	if err == io.EOF {
-		err = nil
-	}
-
-	shuffle(lines, len(lines))
-	return
+	return pcts
 }
 
-func NewConfiguration() *Configuration {
+// Check the flags that only matter to the command and turn the others into
+// the configuration of the run
+func NewConfig() bench.Config {
 
-	if urlsFilePath == "" && url == "" {
+	if urlsFilePath == "" && url == "" && scenarioFilePath == "" && replayFilePath == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if requests == -1 && period == -1 {
+	if requests < 1 && period < 1 && replayFilePath == "" {
 		fmt.Println("Requests or period must be provided")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if requests != -1 && period != -1 {
-		fmt.Println("Only one should be provided: [requests|period]")
+	if outputFormat != "text" && outputFormat != "json" && outputFormat != "csv" {
+		fmt.Println("Report format must be one of: [text|json|csv]")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	configuration := &Configuration{
-		urls:         make([]string, 0),
-		method:       "GET",
-		postData:     nil,
-		keepAlive:    keepAlive,
-		requests:     int64((1 << 63) - 1),
-		authHeader:   authHeader,
-		cookieHeader: cookieHeader,
-		myClient:     fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}}
-
-	if period != -1 {
-		configuration.period = period
-
-		timeout := make(chan bool, 1)
-		go func() {
-			<-time.After(time.Duration(period) * time.Second)
-			timeout <- true
-		}()
-
-		go func() {
-			<-timeout
-			pid := os.Getpid()
-			proc, _ := os.FindProcess(pid)
-			err := proc.Signal(os.Interrupt)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-		}()
+	if outputFormat != "text" && outputFilePath == "" {
+		progress = os.Stderr
 	}
 
-	if requests != -1 {
-		configuration.requests = requests
+	if seriesInterval < 1 || (seriesFormat != "csv" && seriesFormat != "json") {
+		fmt.Println("Time series interval must be positive and format must be one of: [csv|json]")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if urlsFilePath != "" {
-		fileLines, err := readLines(urlsFilePath)
-
-		if err != nil {
-			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", urlsFilePath, err.Error())
-		}
-
-		configuration.urls = fileLines
-		if DEBUG {
-			fmt.Println(configuration.urls)
-		}
+	if agentAddrs != "" && agentToken == "" {
+		fmt.Println("Agents take jobs with their shared token only, -agenttoken must be provided")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if len(url) > 10 {
-		configuration.urls = append(configuration.urls, url)
+	if metricsAddr != "" && isCoordinator() {
+		fmt.Println("Metrics are not served by a coordinator")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if postDataFilePath != "" {
-		configuration.method = "POST"
-
-		data, err := ioutil.ReadFile(postDataFilePath)
-
-		if err != nil {
-			log.Fatalf("Error in ioutil.ReadFile for file path: %s Error: %s", postDataFilePath, err.Error())
-		}
-
-		configuration.postData = data
+	if replayFilePath != "" && (isCoordinator() || replaySpeed <= 0) {
+		fmt.Println("Replay speed must be positive and a replay cannot be split over agents")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	configuration.myClient.ReadTimeout = time.Duration(readTimeout) * time.Millisecond
-	configuration.myClient.WriteTimeout = time.Duration(writeTimeout) * time.Millisecond
-	configuration.myClient.MaxConnsPerHost = clients
-
-	configuration.myClient.Dial = MyDialer()
+	cfg := bench.Config{
+		URL:              url,
+		URLFile:          urlsFilePath,
+		ScenarioFile:     scenarioFilePath,
+		ReplayFile:       replayFilePath,
+		ReplayTarget:     replayTarget,
+		ReplaySpeed:      replaySpeed,
+		PostDataFile:     postDataFilePath,
+		Clients:          clients,
+		DisableKeepAlive: !keepAlive,
+		ConnMode:         connMode,
+		PoolSize:         poolSize,
+		ConnChurn:        connChurn,
+		Endpoints:        endpoints,
+		Balance:          balance,
+		Proxy:            proxyURL,
+		NoProxy:          noProxy,
+		UnixSocket:       unixSocket,
+		FirstClient:      firstClient,
+		Users:            usersFilePath,
+		Login:            loginLine,
+		LoginExtract:     loginExtract,
+		LoginEvery:       time.Duration(relogin) * time.Second,
+		CookieJar:        cookieJar,
+		ReadTimeout:      time.Duration(readTimeout) * time.Millisecond,
+		WriteTimeout:     time.Duration(writeTimeout) * time.Millisecond,
+		Auth:             authHeader,
+		Cookie:           cookieHeader,
+		Expect:           expResult,
+		Check:            checkSpec,
+		ArrivalRate:      arrivalRate,
+		Arrival:          arrivalMode,
+		Percentiles:      parsePercentiles(percentiles),
+		Phases:           phases,
+		TimeThreshold:    timeThreshold,
+		SLA:              slaSpec,
+		Ramp:             time.Duration(rampUp) * time.Second,
+		Warmup:           time.Duration(warmup) * time.Second,
+		Cooldown:         time.Duration(cooldown) * time.Second,
+		Think:            thinkSpec,
+		Pace:             time.Duration(pace) * time.Millisecond,
+		Seed:             seed,
+		Verify:           tlsVerify,
+		CACert:           caCertFile,
+		Cert:             certFile,
+		Key:              keyFile,
+		ServerName:       tlsServerName,
+		MinVersion:       tlsMinVersion,
+		MaxVersion:       tlsMaxVersion,
+		Ciphers:          tlsCiphers,
+		TLSResume:        tlsResume,
+		Protocol:         protocol,
+		H2Transports:     h2ConnCount,
+		Log:              progress}
 
-	return configuration
-}
-
-func MyDialer() func(address string) (conn net.Conn, err error) {
-	return func(address string) (net.Conn, error) {
-		conn, err := net.Dial("tcp", address)
-		if err != nil {
-			return nil, err
-		}
-
-		myConn := &MyConn{Conn: conn}
-
-		return myConn, nil
+	if requests != -1 {
+		cfg.Requests = requests
 	}
+	if period != -1 {
+		cfg.Period = time.Duration(period) * time.Second
+	}
+	if seriesFilePath != "" {
+		cfg.SeriesInterval = time.Duration(seriesInterval) * time.Second
+	}
+	return cfg
 }
 
-func updateElapsed(url string, elapsed int) {
-	m.Lock()
-	respTimeMap[url] = append(respTimeMap[url], elapsed)
-	m.Unlock()
-}
-
-func client(configuration *Configuration, result *Result, done *sync.WaitGroup) {
-	var errSet = make(map[string]struct{})
-	for result.requests < configuration.requests {
-		for _, tmpURL := range configuration.urls {
-			// not a valid URL, ignore it
-			if len(tmpURL) < 10 {
-				continue
-			}
-			// expected contents from response
-			pattern := make([]byte, 0, 256)
-
-			req := fasthttp.AcquireRequest()
-
-			if strings.Contains(tmpURL, "[EXPECT]") {
-				result := strings.Split(tmpURL, "[EXPECT]")
-				pattern = []byte(result[1])
-				tmpURL = result[0]
-			} else if len(expResult) > 0 {
-				pattern = []byte(expResult)
-			}
-
-			if strings.Contains(tmpURL, "[POST]") {
-				result := strings.Split(tmpURL, "[POST]")
-				req.SetRequestURI(result[0])
-				req.Header.SetMethodBytes([]byte("POST"))
-				req.SetBody([]byte(result[1]))
-			} else {
-				req.SetRequestURI(tmpURL)
-				req.Header.SetMethodBytes([]byte("GET"))
-				req.SetBody(configuration.postData)
-			}
-
-			if configuration.keepAlive == true {
-				req.Header.Set("Connection", "keep-alive")
-			} else {
-				req.Header.Set("Connection", "close")
-			}
-
-			// Add set cookie, for example usrId=6
-			if len(configuration.cookieHeader) > 0 {
-				temp := strings.Split(configuration.cookieHeader, "=")
-				req.Header.SetCookie(temp[0], temp[1])
-			}
-
-			if len(configuration.authHeader) > 0 {
-				req.Header.Set("Authorization", configuration.authHeader)
-			}
-
-			start := time.Now()
-			resp := fasthttp.AcquireResponse()
-			err := configuration.myClient.Do(req, resp)
-
-			// Time to get response in ms
-			elapsed := (int)(time.Since(start) / 1000000)
-			updateElapsed(req.URI().String(), elapsed)
-
-			// total request number always increase by one here
-			result.requests++
-
-			if err != nil {
-				if _, ok := errSet[err.Error()]; !ok {
-					errSet[err.Error()] = struct{}{}
-					// debug only
-					fmt.Println(err.Error())
-				}
-				result.networkFailed++
-				fasthttp.ReleaseRequest(req)
-				fasthttp.ReleaseResponse(resp)
-				continue
-			}
-
-			statusCode := resp.StatusCode()
-
-			if statusCode == fasthttp.StatusOK {
-				if len(pattern) > 0 {
-					if bytes.Contains(resp.Body(), pattern) {
-						result.success++
-					} else {
-						if DEBUG {
-							fmt.Println("not match: ", pattern)
-						}
-						result.mismatched++
-					}
-				} else {
-					result.success++
-				}
-			} else {
-				result.badFailed++
-				if DEBUG {
-					fmt.Println(statusCode)
-				}
-			}
-
-			fasthttp.ReleaseRequest(req)
-			fasthttp.ReleaseResponse(resp)
+// Exit with the usage on an invalid configuration
+func newRunner(cfg bench.Config) *bench.Runner {
+	runner, err := bench.New(cfg)
+	if err != nil {
+		if _, ok := err.(*bench.ConfigError); ok {
+			fmt.Println(err.Error())
+			flag.Usage()
+			os.Exit(1)
 		}
+		log.Fatalf("%s", err.Error())
 	}
-
-	done.Done()
+	return runner
 }
 
 func main() {
 
-	startTime := time.Now()
-	var done sync.WaitGroup
-	results := make(map[int]*Result)
-
 	signalChannel := make(chan os.Signal, 2)
 	signal.Notify(signalChannel, os.Interrupt)
-	go func() {
-		_ = <-signalChannel
-		printResults(results, startTime)
-		os.Exit(0)
-	}()
 
 	flag.Parse()
 
-	configuration := NewConfiguration()
+	if agentListenAddr != "" {
+		if agentToken == "" {
+			fmt.Println("Agents take jobs with their shared token only, -agenttoken must be provided")
+			flag.Usage()
+			os.Exit(1)
+		}
+		serveAgents(agentListenAddr)
+		return
+	}
+
+	if os.Getenv(agentEnv) != "" {
+		runAgent(signalChannel)
+		return
+	}
+
+	runner := newRunner(NewConfig())
+
+	if isCoordinator() {
+		finish(runCoordinator(runner, signalChannel))
+		return
+	}
 
 	goMaxProcs := os.Getenv("GOMAXPROCS")
 
@@ -538,16 +406,30 @@ func main() {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	fmt.Printf("Dispatching %d clients\n", clients)
+	// an interrupt ends the run with the results so far, a second one
+	// right away
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-signalChannel
+		cancel()
+		<-signalChannel
+		os.Exit(1)
+	}()
 
-	done.Add(clients)
-	for i := 0; i < clients; i++ {
-		result := &Result{}
-		results[i] = result
-		go client(configuration, result, &done)
+	if metricsAddr != "" {
+		serveMetrics(metricsAddr, runner)
+	}
+	report, err := runner.Run(ctx)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	finish(report)
+}
 
+// Print the results and exit with exitSLAViolated when a SLA rule failed
+func finish(report *bench.Report) {
+	printResults(report)
+	if report.SLA != nil && !report.SLA.Passed {
+		os.Exit(exitSLAViolated)
 	}
-	fmt.Println("Waiting for results...")
-	done.Wait()
-	printResults(results, startTime)
 }